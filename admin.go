@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// AdminAction is a change made to a user by an economy admin.
+type AdminAction struct {
+	AdminID string
+	UserID  string
+	Action  string
+	// Detail describes what was changed, such as the balance before and after.
+	Detail string
+	Reason string
+	Time   int64
+}
+
+// AdminChange is a change an economy admin makes to a user, which stores apply in a single transaction along with the
+// record of its Action.
+type AdminChange struct {
+	Action AdminAction
+	// Balance replaces the user's balance if it is set, otherwise Amount is added to it if that is set.
+	Balance *big.Int
+	Amount  *big.Int
+	// LedgerReason is the reason the balance change is recorded in the ledger for.
+	LedgerReason string
+	ResetStats   bool
+	ResetDaily   bool
+}
+
+func (change AdminChange) changesBalance() bool {
+	return change.Balance != nil || change.Amount != nil
+}
+
+// balance returns the balance the change leaves the user with.
+func (change AdminChange) balance(old *big.Int) *big.Int {
+	switch {
+	case change.Balance != nil:
+		return new(big.Int).Set(change.Balance)
+	case change.Amount != nil:
+		return new(big.Int).Add(old, change.Amount)
+	}
+	return new(big.Int).Set(old)
+}
+
+// record returns the action to record for the change, whose detail starts with the balance before and after it.
+func (change AdminChange) record(old *big.Int, balance *big.Int) AdminAction {
+	action := change.Action
+	if change.changesBalance() {
+		action.Detail = "$" + old.String() + "\u27A4$" + balance.String() + action.Detail
+	}
+	return action
+}
+
+// requireAdmin refuses the command to users who are not economy admins.
+func requireAdmin(name string, next handler) handler {
+	return func(ctx Context, args Args) error {
+		admin, err := isAdmin(ctx.Author().ID)
+		if err != nil {
+			return err
+		}
+		if !admin {
+			ctx.Reply(ctx.Author().Mention + " you do not have the necessary permissions to use `" + name + "` (Economy Admin).")
+			return nil
+		}
+		return next(ctx, args)
+	}
+}
+
+// adminTarget returns the user an admin command acts on, replying and returning ok=false if there is no such user.
+func adminTarget(ctx Context, args Args) (user User, ok bool) {
+	id := args.UserID("user")
+	user, err := createUser(ctx, id)
+	if err != nil {
+		ctx.Reply(ctx.Author().Mention + " " + id + " is not a valid User ID.\nPlease ping the user or copy their ID and paste it.")
+		return User{}, false
+	}
+	return user, true
+}
+
+// adminAction returns the action the admin is taking on the user.
+func adminAction(ctx Context, user User, action string, detail string, reason string) AdminAction {
+	return AdminAction{
+		AdminID: ctx.Author().ID,
+		UserID:  user.ID,
+		Action:  action,
+		Detail:  detail,
+		Reason:  reason,
+		Time:    time.Now().Unix(),
+	}
+}
+
+// recordAdminAction records the action and tells the admin it was done.
+func recordAdminAction(ctx Context, user User, action string, detail string, reason string) error {
+	err := store.RecordAdminAction(adminAction(ctx, user, action, detail, reason))
+	if err != nil {
+		return fmt.Errorf("could not record %s of %s by %s: %w", action, user.ID, ctx.Author().ID, err)
+	}
+	replyAdminAction(ctx, user, action, detail, reason)
+	return nil
+}
+
+// applyAdminChange applies the change along with its record, returning ok=false if the user's balance would go below
+// zero.
+func applyAdminChange(ctx Context, user User, change AdminChange) (ok bool, err error) {
+	old, balance, err := store.ApplyAdminChange(change)
+	if err == ErrInsufficientFunds {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not apply %s of %s by %s: %w", change.Action.Action, user.ID, ctx.Author().ID, err)
+	}
+	action := change.record(old, balance)
+	replyAdminAction(ctx, user, action.Action, action.Detail, action.Reason)
+	return true, nil
+}
+
+// replyAdminAction tells the admin the action was done.
+func replyAdminAction(ctx Context, user User, action string, detail string, reason string) {
+	ctx.ReplyEmbed(&Embed{
+		Color: 0x00ff00,
+		Fields: []EmbedField{
+			{
+				Name:   action + " " + user.Name,
+				Value:  detail + "\nReason: " + reason,
+				Inline: false,
+			},
+		},
+		Title: "Admin",
+	})
+}
+
+// parseAdminAmount parses an amount as parseNumber does, except that it can be negative.
+func parseAdminAmount(ctx Context, amount string) (*big.Int, bool) {
+	a, err := parseNumber(strings.TrimPrefix(amount, "-"))
+	if err != nil {
+		ctx.Reply(err.Error())
+		return nil, false
+	}
+	if strings.HasPrefix(amount, "-") {
+		a.Neg(a)
+	}
+	return a, true
+}
+
+func setbal(ctx Context, args Args) error {
+	user, ok := adminTarget(ctx, args)
+	if !ok {
+		return nil
+	}
+	reason := args.String("reason")
+	amount, ok := parseAdminAmount(ctx, args.String("amount"))
+	if !ok {
+		return nil
+	}
+	if amount.Sign() < 0 {
+		ctx.Reply("Balances cannot be negative.")
+		return nil
+	}
+	_, err := applyAdminChange(ctx, user, AdminChange{
+		Action:       adminAction(ctx, user, "setbal", "", reason),
+		Balance:      amount,
+		LedgerReason: reasonAdminSet,
+	})
+	return err
+}
+
+func addbal(ctx Context, args Args) error {
+	user, ok := adminTarget(ctx, args)
+	if !ok {
+		return nil
+	}
+	reason := args.String("reason")
+	amount, ok := parseAdminAmount(ctx, args.String("amount"))
+	if !ok {
+		return nil
+	}
+	ok, err := applyAdminChange(ctx, user, AdminChange{
+		Action:       adminAction(ctx, user, "addbal", "", reason),
+		Amount:       amount,
+		LedgerReason: reasonAdminAdd,
+	})
+	if err == nil && !ok {
+		ctx.Reply(user.Name + " does not have $" + new(big.Int).Neg(amount).String() + ", use `setbal` to set their balance instead.")
+	}
+	return err
+}
+
+func resetstats(ctx Context, args Args) error {
+	user, ok := adminTarget(ctx, args)
+	if !ok {
+		return nil
+	}
+	reason := args.String("reason")
+	_, err := applyAdminChange(ctx, user, AdminChange{
+		Action:     adminAction(ctx, user, "resetstats", "Stats reset", reason),
+		ResetStats: true,
+	})
+	return err
+}
+
+// resetuser puts the user back where they started, their tier and ledger are kept.
+func resetuser(ctx Context, args Args) error {
+	user, ok := adminTarget(ctx, args)
+	if !ok {
+		return nil
+	}
+	reason := args.String("reason")
+	_, err := applyAdminChange(ctx, user, AdminChange{
+		Action:       adminAction(ctx, user, "resetuser", ", stats and daily reset", reason),
+		Balance:      config.StartingBalance,
+		LedgerReason: reasonAdminReset,
+		ResetStats:   true,
+		ResetDaily:   true,
+	})
+	return err
+}
+
+func economyBan(ctx Context, args Args) error {
+	user, ok := adminTarget(ctx, args)
+	if !ok {
+		return nil
+	}
+	reason := args.String("reason")
+	// Admins can only be banned by owners, and owners not at all
+	target, err := userTier(user.ID)
+	if err != nil {
+		return err
+	}
+	owner, err := hasTier(ctx.Author().ID, userOwner)
+	if err != nil {
+		return err
+	}
+	if target == userOwner || (target == userEconomyAdmin && !owner) {
+		ctx.Reply(ctx.Author().Mention + " you cannot ban " + user.Name + ".")
+		return nil
+	}
+	if target == userBanned {
+		ctx.Reply(user.Name + " is already banned from the economy.")
+		return nil
+	}
+	err = store.SetUserTier(user.ID, userBanned)
+	if err != nil {
+		return fmt.Errorf("could not ban %s: %w", user.ID, err)
+	}
+	return recordAdminAction(ctx, user, "economy-ban", "Banned from the economy", reason)
+}
+
+func economyUnban(ctx Context, args Args) error {
+	user, ok := adminTarget(ctx, args)
+	if !ok {
+		return nil
+	}
+	reason := args.String("reason")
+	banned, err := isBanned(user.ID)
+	if err != nil {
+		return err
+	}
+	if !banned {
+		ctx.Reply(user.Name + " is not banned from the economy.")
+		return nil
+	}
+	err = store.SetUserTier(user.ID, userDefault)
+	if err != nil {
+		return fmt.Errorf("could not unban %s: %w", user.ID, err)
+	}
+	return recordAdminAction(ctx, user, "economy-unban", "Unbanned from the economy", reason)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxAmountDigits bounds the exponent of typed amounts, so that nobody can make the bot compute 1e999999999.
+const maxAmountDigits = 1000
+
+// amount is an amount of money typed by a user, either absolute or a fraction of their balance.
+type amount struct {
+	// value is nil if the amount is a fraction of the balance.
+	value    *big.Int
+	fraction *big.Rat
+}
+
+// numberPattern matches a decimal number with optional thousands separators, exponent and suffix, such as 1,500,
+// 2.5k or 1e6. The integer part is in group 1, the fraction in group 2, the exponent in group 3 and the suffix in group 4.
+var numberPattern = regexp.MustCompile(`^(\d{1,3}(?:,\d{3})+|\d*)(?:\.(\d*))?(?:[eE]([+-]?\d+))?([kKmMbBtT]?)$`)
+
+// percentPattern matches a decimal percentage such as 10% or 12.5%.
+var percentPattern = regexp.MustCompile(`^(?:\d+(?:\.\d*)?|\.\d+)%$`)
+
+// suffixDigits are the powers of ten of each suffix.
+var suffixDigits = map[string]int{
+	"":  0,
+	"k": 3,
+	"m": 6,
+	"b": 9,
+	"t": 12,
+}
+
+const amountHelp = "Amounts are whole numbers of dollars such as 500, 1,500, 2.5k, 1e6, 10%, half or all."
+
+// parseNumber parses a non-negative whole number of dollars at full precision.
+// Thousands separators, a decimal fraction, an exponent and a k, m, b or t suffix are allowed, as long as the result is
+// a whole number.
+func parseNumber(s string) (*big.Int, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, usageError{"Invalid amount: " + s + " (amounts cannot be negative)"}
+	}
+	m := numberPattern.FindStringSubmatch(s)
+	if m == nil || (m[1] == "" && m[2] == "") {
+		return nil, usageError{"Invalid amount: " + s + "\n" + amountHelp}
+	}
+	digits := suffixDigits[strings.ToLower(m[4])]
+	if m[3] != "" {
+		exp, err := strconv.Atoi(m[3])
+		if err != nil || exp > maxAmountDigits || exp < -maxAmountDigits {
+			return nil, usageError{"Invalid amount: " + s + " (the exponent is too large)"}
+		}
+		digits += exp
+	}
+	if digits > maxAmountDigits {
+		return nil, usageError{"Invalid amount: " + s + " (the exponent is too large)"}
+	}
+
+	number := strings.ReplaceAll(m[1], ",", "")
+	if number == "" {
+		number = "0"
+	}
+	if m[2] != "" {
+		number += "." + m[2]
+	}
+	r, _ := new(big.Rat).SetString(number)
+	if digits >= 0 {
+		r.Mul(r, new(big.Rat).SetInt(pow10(digits)))
+	} else {
+		r.Quo(r, new(big.Rat).SetInt(pow10(-digits)))
+	}
+	if !r.IsInt() {
+		return nil, usageError{"Invalid amount: " + s + " (amounts must be whole dollars)"}
+	}
+	return new(big.Int).Set(r.Num()), nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// parseAmount parses an amount of money: a number as accepted by parseNumber, a percentage of the balance such as
+// 12.5%, half, or all (also max).
+func parseAmount(s string) (amount, error) {
+	switch strings.ToLower(s) {
+	case "all", "max":
+		return amount{fraction: big.NewRat(1, 1)}, nil
+	case "half":
+		return amount{fraction: big.NewRat(1, 2)}, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		if !percentPattern.MatchString(s) {
+			return amount{}, usageError{"Invalid amount: " + s + "\n" + amountHelp}
+		}
+		percentage, _ := new(big.Rat).SetString(strings.TrimSuffix(s, "%"))
+		if percentage.Cmp(big.NewRat(100, 1)) > 0 {
+			return amount{}, usageError{"Invalid amount: " + s + " (percentages must be between 0% and 100%)"}
+		}
+		return amount{fraction: percentage.Quo(percentage, big.NewRat(100, 1))}, nil
+	}
+	value, err := parseNumber(s)
+	if err != nil {
+		return amount{}, err
+	}
+	return amount{value: value}, nil
+}
+
+// fractionOf returns x * f rounded down, computed exactly from the shortest decimal representation of f.
+func fractionOf(x *big.Int, f float64) *big.Int {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return ratOf(x, r)
+}
+
+// ratOf returns x * r rounded towards zero.
+func ratOf(x *big.Int, r *big.Rat) *big.Int {
+	n := new(big.Int).Mul(x, r.Num())
+	return n.Quo(n, r.Denom())
+}
+
+// of returns the amount out of the balance, rounding fractions down.
+// It returns ok=false if the amount is more than the balance.
+func (a amount) of(balance *big.Int) (*big.Int, bool) {
+	if a.value == nil {
+		amt := new(big.Int).Mul(balance, a.fraction.Num())
+		return amt.Quo(amt, a.fraction.Denom()), true
+	}
+	return new(big.Int).Set(a.value), a.value.Cmp(balance) <= 0
+}
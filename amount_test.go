@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"0", "0"},
+		{"500", "500"},
+		{"1,500", "1500"},
+		{"1,234,567", "1234567"},
+		{"2.5k", "2500"},
+		{"2.5K", "2500"},
+		{"3m", "3000000"},
+		{"1.25b", "1250000000"},
+		{"4t", "4000000000000"},
+		{"1e6", "1000000"},
+		{"1E6", "1000000"},
+		{"1.5e3", "1500"},
+		{"15e-1k", "1500"},
+		{"1e+2", "100"},
+		{".5k", "500"},
+		{"5.", "5"},
+		{"1,500.0", "1500"},
+		{"1e2k", "100000"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+		{"1e1000", "1" + strings.Repeat("0", 1000)},
+	}
+	for _, tt := range tests {
+		got, err := parseNumber(tt.in)
+		if err != nil {
+			t.Errorf("parseNumber(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("parseNumber(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseNumberErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "Invalid amount"},
+		{".", "Invalid amount"},
+		{"k", "Invalid amount"},
+		{"abc", "Invalid amount"},
+		{"-5", "cannot be negative"},
+		{"1,50", "Invalid amount"},
+		{"1,5000", "Invalid amount"},
+		{"12,345,67", "Invalid amount"},
+		{"1.5", "whole dollars"},
+		{"1.0005k", "whole dollars"},
+		{"1e-1", "whole dollars"},
+		{"5x", "Invalid amount"},
+		{"0x10", "Invalid amount"},
+		{"1e", "Invalid amount"},
+		{"1e1001", "too large"},
+		{"1e-1001", "too large"},
+		{"1e999k", "too large"},
+		{"1e99999999999999999999", "too large"},
+	}
+	for _, tt := range tests {
+		_, err := parseNumber(tt.in)
+		if err == nil {
+			t.Errorf("parseNumber(%q) succeeded, want an error", tt.in)
+			continue
+		}
+		if _, ok := err.(usageError); !ok {
+			t.Errorf("parseNumber(%q) returned %T, want a usageError", tt.in, err)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseNumber(%q) = %q, want it to mention %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	balance := big.NewInt(1000)
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"all", 1000, true},
+		{"ALL", 1000, true},
+		{"max", 1000, true},
+		{"half", 500, true},
+		{"Half", 500, true},
+		{"10%", 100, true},
+		{"12.5%", 125, true},
+		{"0.15%", 1, true},
+		{".5%", 5, true},
+		{"0%", 0, true},
+		{"100%", 1000, true},
+		{"999", 999, true},
+		{"1k", 1000, true},
+		{"1,001", 1001, false},
+	}
+	for _, tt := range tests {
+		a, err := parseAmount(tt.in)
+		if err != nil {
+			t.Errorf("parseAmount(%q) failed: %v", tt.in, err)
+			continue
+		}
+		got, ok := a.of(balance)
+		if got.Cmp(big.NewInt(tt.want)) != 0 || ok != tt.ok {
+			t.Errorf("parseAmount(%q).of(%s) = %s, %t, want %d, %t", tt.in, balance, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseAmountErrors(t *testing.T) {
+	tests := []string{
+		"%",
+		"-5%",
+		"101%",
+		"100.5%",
+		"0x10%",
+		"0b11%",
+		"0o7%",
+		"1e1%",
+		"1/2%",
+		"1_0%",
+		"5k%",
+		"1,000%",
+		"all%",
+		"quarter",
+	}
+	for _, in := range tests {
+		_, err := parseAmount(in)
+		if err == nil {
+			t.Errorf("parseAmount(%q) succeeded, want an error", in)
+			continue
+		}
+		if _, ok := err.(usageError); !ok {
+			t.Errorf("parseAmount(%q) returned %T, want a usageError", in, err)
+		}
+	}
+}
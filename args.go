@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenize splits a command into arguments at runs of whitespace.
+// Arguments can be wrapped in double or single quotes to include whitespace, inside which a backslash escapes the next
+// character. Quotes inside an argument are kept as they are, so that words like "don't" can be typed.
+// If a quote is never closed, the arguments read so far are returned along with an error.
+func tokenize(s string) ([]string, error) {
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	quoteAt := 0
+	escaped := false
+	for i, c := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case quote != 0 && c == '\\':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case (c == '"' || c == '\'') && !inArg:
+			quote = c
+			quoteAt = i
+			inArg = true
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	if quote != 0 {
+		return args, fmt.Errorf("the %c at character %d is never closed", quote, quoteAt+1)
+	}
+	return args, nil
+}
+
+type argKind int
+
+const (
+	// argString is a single argument.
+	argString argKind = iota
+	// argText is the rest of the arguments joined by spaces.
+	argText
+	// argUser is a user mention or ID.
+	argUser
+	// argAmount is an amount of money, see parseAmount.
+	argAmount
+	// argPage is a page number, starting at 1.
+	argPage
+	// argChoice is one of the param's choices, ignoring case.
+	argChoice
+	// argCommand is the name or an alias of a command, it is parsed to the command's name.
+	argCommand
+)
+
+// param is an argument in the signature of a command.
+type param struct {
+	Name     string
+	Kind     argKind
+	Optional bool
+	Choices  []string
+	// Description explains the argument to users of the slash command.
+	Description string
+}
+
+// signature lists the arguments of a command in order.
+// An optional argument that does not parse is skipped, so that it can be followed by an argument of another kind.
+type signature []param
+
+// usage returns how to invoke the command named name, such as "share <amount> <user>".
+func (sig signature) usage(name string) string {
+	usage := name
+	for _, p := range sig {
+		text := p.Name
+		if p.Kind == argChoice {
+			text = strings.Join(p.Choices, "|")
+		}
+		if p.Optional {
+			usage += " [" + text + "]"
+		} else {
+			usage += " <" + text + ">"
+		}
+	}
+	return usage
+}
+
+// usageError is returned when arguments do not match a signature, it is shown to the user along with the usage.
+type usageError struct {
+	msg string
+}
+
+func (err usageError) Error() string {
+	return err.msg
+}
+
+// parse parses the arguments according to the signature. Arguments left over once every param is parsed are ignored.
+func (sig signature) parse(raw []string) (Args, error) {
+	args := Args{Raw: raw, values: make(map[string]interface{})}
+	// skipped is the error of the first optional argument that was skipped, which is the likely culprit if nothing
+	// else accepts the argument either
+	var skipped error
+	i := 0
+	for _, p := range sig {
+		if i >= len(raw) {
+			if !p.Optional {
+				return args, usageError{"Missing " + p.Name + "."}
+			}
+			continue
+		}
+		if p.Kind == argText {
+			args.values[p.Name] = strings.Join(raw[i:], " ")
+			i = len(raw)
+			continue
+		}
+		v, err := p.parse(raw[i])
+		if err != nil {
+			if !p.Optional {
+				return args, err
+			}
+			if skipped == nil {
+				skipped = err
+			}
+			continue
+		}
+		args.values[p.Name] = v
+		i++
+	}
+	if i < len(raw) && skipped != nil {
+		return args, skipped
+	}
+	return args, nil
+}
+
+func (p param) parse(arg string) (interface{}, error) {
+	switch p.Kind {
+	case argUser:
+		id, err := getID(arg)
+		if err != nil || len(id) < 15 {
+			return nil, usageError{"`" + arg + "` is not a valid User ID.\nPlease ping the user or copy their ID and paste it."}
+		}
+		return id, nil
+	case argAmount:
+		return parseAmount(arg)
+	case argPage:
+		if !isPageNumber(arg) {
+			return nil, usageError{"Invalid page number: " + arg}
+		}
+		page, _ := strconv.Atoi(arg)
+		if page < 1 {
+			page = 1
+		}
+		return page, nil
+	case argChoice:
+		for _, choice := range p.Choices {
+			if strings.EqualFold(arg, choice) {
+				return choice, nil
+			}
+		}
+		return nil, usageError{fmt.Sprintf("Invalid %s: %s (expected %s)", p.Name, arg, strings.Join(p.Choices, ", "))}
+	case argCommand:
+		cmd, exists := lookupCommand(arg)
+		if !exists {
+			return nil, usageError{"Unknown command: " + arg}
+		}
+		return cmd.Name, nil
+	}
+	return arg, nil
+}
+
+// isPageNumber reports whether the argument is a page number rather than a user ID.
+// User IDs are snowflakes, which are far longer than any reasonable page number.
+func isPageNumber(arg string) bool {
+	_, err := strconv.Atoi(arg)
+	return err == nil && len(arg) < 15
+}
+
+// Args are the arguments of an invocation, parsed according to the signature of the command.
+type Args struct {
+	// Raw are the arguments as they were typed.
+	Raw    []string
+	values map[string]interface{}
+}
+
+// Has reports whether the argument was given.
+func (a Args) Has(name string) bool {
+	_, exists := a.values[name]
+	return exists
+}
+
+// String returns the string or text argument, or "" if it was not given.
+func (a Args) String(name string) string {
+	s, _ := a.values[name].(string)
+	return s
+}
+
+// UserID returns the ID of the user argument, or "" if it was not given.
+func (a Args) UserID(name string) string {
+	return a.String(name)
+}
+
+// Amount returns the amount argument, or an amount of 0 if it was not given.
+func (a Args) Amount(name string) amount {
+	amt, exists := a.values[name].(amount)
+	if !exists {
+		return amount{value: big.NewInt(0)}
+	}
+	return amt
+}
+
+// Page returns the page argument, or 1 if it was not given.
+func (a Args) Page(name string) int {
+	page, exists := a.values[name].(int)
+	if !exists {
+		return 1
+	}
+	return page
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"   ", []string{}},
+		{"bj 500", []string{"bj", "500"}},
+		{"  bj \t 500\n", []string{"bj", "500"}},
+		{`ban 123 "spamming the channel"`, []string{"ban", "123", "spamming the channel"}},
+		{`ban 123 'spamming the channel'`, []string{"ban", "123", "spamming the channel"}},
+		{`a "" b`, []string{"a", "", "b"}},
+		{`a ''`, []string{"a", ""}},
+		{`"it's" 'say "hi"'`, []string{"it's", `say "hi"`}},
+		{`don't`, []string{"don't"}},
+		{`say"hi"`, []string{`say"hi"`}},
+		{`"a"b`, []string{"ab"}},
+		{`"a b"c d`, []string{"a bc", "d"}},
+		{`"a \" b"`, []string{`a " b`}},
+		{`'a \' b'`, []string{"a ' b"}},
+		{`"a \\ b"`, []string{`a \ b`}},
+		{`"\n"`, []string{"n"}},
+		{`a\ b`, []string{`a\`, "b"}},
+		{`a\"b`, []string{`a\"b`}},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.in)
+		if err != nil {
+			t.Errorf("tokenize(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeUnclosedQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  string
+	}{
+		{`ban 123 "spamming`, []string{"ban", "123", "spamming"}, `the " at character 9 is never closed`},
+		{`'`, []string{""}, "the ' at character 1 is never closed"},
+		{`"a \"`, []string{`a "`}, `the " at character 1 is never closed`},
+		{`"a" 'b`, []string{"a", "b"}, "the ' at character 5 is never closed"},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.in)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("tokenize(%q) returned error %v, want %q", tt.in, err, tt.err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,1065 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cardTypes = []string{"A", "2", "3", "4", "5", "6", "7", "8", "9", "10", "J", "Q", "K"}
+
+// blackjackHand is one of the player's hands, the player has more than one once they split.
+type blackjackHand struct {
+	Cards []string `json:"cards"`
+	Bet   *big.Int `json:"bet"`
+	// Doubled is set once the player doubled down on the hand, Bet is then twice what it was.
+	Doubled bool `json:"doubled,omitempty"`
+	// Split is set on hands that were split from a pair, a two card 21 on them is not a blackjack.
+	Split bool `json:"split,omitempty"`
+	// SplitAces is set on hands that were split from a pair of aces, they only get one more card.
+	SplitAces bool `json:"split_aces,omitempty"`
+	Stood     bool `json:"stood,omitempty"`
+}
+
+type blackjackGame struct {
+	deck map[string]int
+	// hands are the player's hands in the order they are played.
+	hands []*blackjackHand
+	// current is the index of the hand being played, it is len(hands) once every hand is done.
+	current int
+	dealer  []string
+	msg     MessageRef
+	time    int64
+	rng     rng
+	// rules are the rules of the guild the game started in, changing them does not affect games in progress.
+	rules blackjackRules
+	// offering is set while the player is offered insurance, or even money if they have a blackjack.
+	// No other move can be made until they take or decline it.
+	offering bool
+	// insurance describes how the insurance the player took did, it is empty if they did not take any.
+	insurance string
+}
+
+// blackjackSessions holds the games in progress, a player can only have one game at a time.
+// It is created once the config is loaded.
+var blackjackSessions *sessionManager
+
+var blackjackButtons = []Button{
+	{Label: "Hit", ID: "bj_hit", Style: ButtonSuccess},
+	{Label: "Stand", ID: "bj_stand", Style: ButtonSuccess},
+	{Label: "Forfeit", ID: "bj_forfeit", Style: ButtonDanger},
+}
+
+var blackjackDoubleButton = Button{Label: "Double Down", ID: "bj_double", Style: ButtonSecondary}
+
+var blackjackSplitButton = Button{Label: "Split", ID: "bj_split", Style: ButtonSecondary}
+
+var blackjackInsuranceButton = Button{Label: "Insurance", ID: "bj_insurance", Style: ButtonSecondary}
+
+var blackjackEvenMoneyButton = Button{Label: "Even Money", ID: "bj_even_money", Style: ButtonSecondary}
+
+var blackjackDeclineButton = Button{Label: "Decline", ID: "bj_decline", Style: ButtonSuccess}
+
+// staked returns the total bet on every hand, all of which was taken from the player when it was bet.
+func (game *blackjackGame) staked() *big.Int {
+	staked := new(big.Int)
+	for _, hand := range game.hands {
+		staked.Add(staked, hand.Bet)
+	}
+	return staked
+}
+
+// playing returns the hand being played, or nil once every hand is done.
+func (game *blackjackGame) playing() *blackjackHand {
+	if game.current >= len(game.hands) {
+		return nil
+	}
+	return game.hands[game.current]
+}
+
+// natural reports whether the player was dealt a blackjack.
+func (game *blackjackGame) natural() bool {
+	hand := game.hands[0]
+	return len(game.hands) == 1 && len(hand.Cards) == 2 && getHandTotal(&hand.Cards) == 21
+}
+
+// busted reports whether every hand of the player went over 21.
+func (game *blackjackGame) busted() bool {
+	for _, hand := range game.hands {
+		if getHandTotal(&hand.Cards) <= 21 {
+			return false
+		}
+	}
+	return true
+}
+
+// dealerNatural reports whether the dealer was dealt a blackjack.
+func (game *blackjackGame) dealerNatural() bool {
+	return len(game.dealer) == 2 && getHandTotal(&game.dealer) == 21
+}
+
+// insuranceBet returns the side bet of insurance, which is half the bet.
+func (game *blackjackGame) insuranceBet() *big.Int {
+	return new(big.Int).Rsh(game.hands[0].Bet, 1)
+}
+
+// canHit reports whether the hand can be dealt another card by the player.
+// Doubled hands and split aces only ever get one more card.
+func (game *blackjackGame) canHit(hand *blackjackHand) bool {
+	return !hand.Doubled && !hand.SplitAces
+}
+
+// canDouble reports whether the player can double down on the hand, which is only on its first decision.
+func (game *blackjackGame) canDouble(hand *blackjackHand) bool {
+	return game.canHit(hand) && len(hand.Cards) == 2
+}
+
+// canSplit reports whether the hand is a pair that can be split into two hands.
+// Aces can only be split again if config.BlackjackResplitAces is set.
+func (game *blackjackGame) canSplit(hand *blackjackHand) bool {
+	if hand.Doubled || len(hand.Cards) != 2 || hand.Cards[0] != hand.Cards[1] || len(game.hands) >= config.BlackjackMaxHands {
+		return false
+	}
+	return !hand.SplitAces || config.BlackjackResplitAces
+}
+
+// done reports whether the player has no decisions left on the hand.
+// Five cards only end the hand under the classic rules, where they win.
+func (game *blackjackGame) done(hand *blackjackHand) bool {
+	switch {
+	case hand.Stood, getHandTotal(&hand.Cards) >= 21, game.rules.Classic && len(hand.Cards) == 5:
+		return true
+	case hand.Doubled:
+		return len(hand.Cards) > 2
+	case hand.SplitAces:
+		return len(hand.Cards) > 1 && !game.canSplit(hand)
+	}
+	return false
+}
+
+// advance moves on to the next hand the player has decisions left on and reports whether every hand is done.
+func (game *blackjackGame) advance() bool {
+	for game.current < len(game.hands) && game.done(game.hands[game.current]) {
+		game.current++
+	}
+	return game.current >= len(game.hands)
+}
+
+// buttons returns the moves the player can make on the hand being played.
+func (game *blackjackGame) buttons() []Button {
+	if game.offering {
+		if game.natural() {
+			return []Button{blackjackEvenMoneyButton, blackjackDeclineButton}
+		}
+		return []Button{blackjackInsuranceButton, blackjackDeclineButton}
+	}
+	hand := game.playing()
+	if hand == nil {
+		return nil
+	}
+	buttons := make([]Button, 0, len(blackjackButtons)+2)
+	if game.canHit(hand) {
+		buttons = append(buttons, blackjackButtons[0])
+	}
+	buttons = append(buttons, blackjackButtons[1])
+	if game.canDouble(hand) {
+		buttons = append(buttons, blackjackDoubleButton)
+	}
+	if game.canSplit(hand) {
+		buttons = append(buttons, blackjackSplitButton)
+	}
+	return append(buttons, blackjackButtons[2])
+}
+
+// fields shows every hand of the player and the dealer's hand, whose second card is hidden until it is revealed.
+// Once the game is settled, outcomes describe how each hand of the player did.
+func (game *blackjackGame) fields(reveal bool, outcomes []string) []EmbedField {
+	fields := make([]EmbedField, 0, len(game.hands)+1)
+	for i, hand := range game.hands {
+		name := "Player"
+		value := generateHandString(&hand.Cards)
+		if len(game.hands) > 1 {
+			name = fmt.Sprintf("Hand %d", i+1)
+			if i == game.current {
+				name = "▶ " + name
+			}
+			value += "\nBet: " + hand.Bet.String()
+		}
+		if hand.Doubled {
+			value += "\nDoubled down"
+		}
+		if outcomes != nil && len(game.hands) > 1 {
+			value += "\n" + outcomes[i]
+		}
+		fields = append(fields, EmbedField{Name: name, Value: value, Inline: true})
+	}
+	dealer := "`" + game.dealer[0] + "` `?`"
+	if reveal {
+		dealer = generateHandString(&game.dealer)
+	}
+	fields = append(fields, EmbedField{Name: "Dealer", Value: dealer, Inline: true})
+	switch {
+	case game.offering && game.natural():
+		fields = append(fields, EmbedField{
+			Name:   "Even Money",
+			Value:  "The dealer shows an ace. Take " + game.hands[0].Bet.String() + " now instead of risking a push against a dealer blackjack?",
+			Inline: false,
+		})
+	case game.offering:
+		fields = append(fields, EmbedField{
+			Name:   "Insurance",
+			Value:  "The dealer shows an ace. Bet " + game.insuranceBet().String() + " that they have a blackjack, which pays 2:1?",
+			Inline: false,
+		})
+	case game.insurance != "":
+		fields = append(fields, EmbedField{Name: "Insurance", Value: game.insurance, Inline: false})
+	}
+	return fields
+}
+
+// update shows the game as it is after a move that did not end it.
+func (game *blackjackGame) update(ctx Context) {
+	ctx.Edit(game.msg, Response{
+		Embed: &Embed{
+			Color:  0xffff00,
+			Fields: append(game.fields(false, nil), gameFields(game.rng)...),
+			Title:  "Blackjack",
+		},
+		Buttons: game.buttons(),
+	})
+}
+
+func blackjack(ctx Context, args Args) error {
+	_, err := createUser(ctx, ctx.Author().ID)
+	if err != nil {
+		return err
+	}
+	bet, ok, err := getBet(ctx, args.Amount("bet"))
+	if !ok {
+		return err
+	}
+	if bet.Cmp(big.NewInt(0)) != 1 {
+		ctx.Reply("You must bet more than $0.")
+		return nil
+	}
+	rules, err := getBlackjackRules(ctx.GuildID())
+	if err != nil {
+		return err
+	}
+	r, err := newGameRNG(ctx.Author().ID, "blackjack")
+	if err != nil {
+		return err
+	}
+
+	deck := map[string]int{
+		"A":  4,
+		"2":  4,
+		"3":  4,
+		"4":  4,
+		"5":  4,
+		"6":  4,
+		"7":  4,
+		"8":  4,
+		"9":  4,
+		"10": 4,
+		"J":  4,
+		"Q":  4,
+		"K":  4,
+	}
+
+	//Set deck to contain 6 decks
+	for i := 0; i < 6; i++ {
+		for _, card := range cardTypes {
+			deck[card] += 4
+		}
+	}
+
+	var dealerHand = make([]string, 0)
+	var playerHand = make([]string, 0)
+
+	dealerHand = append(dealerHand, getRandomCard(&deck, r))
+	dealerHand = append(dealerHand, getRandomCard(&deck, r))
+	// The classic dealer is only dealt a blackjack when their up card is an ace, which the player is offered insurance against
+	for rules.Classic {
+		if getHandTotal(&dealerHand) >= 21 && dealerHand[0] != "A" {
+			deck[dealerHand[len(dealerHand)-1]]++
+			dealerHand = remove(dealerHand, len(dealerHand)-1)
+		} else if len(dealerHand) < 2 {
+			dealerHand = append(dealerHand, getRandomCard(&deck, r))
+		} else {
+			break
+		}
+	}
+
+	playerHand = append(playerHand, getRandomCard(&deck, r))
+	playerHand = append(playerHand, getRandomCard(&deck, r))
+	//	dealerHand = append(dealerHand, getRandomCard(&deck))
+
+	key := sessionKey{UserID: ctx.Author().ID}
+	game := &blackjackGame{
+		deck:   deck,
+		hands:  []*blackjackHand{{Cards: playerHand, Bet: bet}},
+		dealer: dealerHand,
+		time:   time.Now().Unix(),
+		rng:    r,
+		rules:  rules,
+		// Insurance is offered before anything else when the dealer shows an ace
+		offering: dealerHand[0] == "A",
+	}
+	if blackjackSessions.Start(key, game) == ErrSessionExists {
+		finishGame(logFor(ctx), r, "cancelled")
+		ctx.Reply("You already have a game in progress.")
+		return nil
+	}
+
+	// The dealer peeks under a ten right away, their blackjack ends the game before the player plays
+	peeked := rules.Peek && !game.offering && game.dealerNatural()
+	if peeked {
+		game.current = len(game.hands)
+	}
+
+	// Take the bet up front so that it cannot be spent elsewhere while the game is in progress.
+	// A blackjack is paid back 2.5 times along with it, so that it is wagered like any other bet.
+	natural := getHandTotal(&playerHand) == 21 && !game.offering && !peeked
+	payout := ratOf(bet, big.NewRat(3, 2))
+	changes := []BalanceChange{{ID: key.UserID, Amount: new(big.Int).Neg(bet), Reason: reasonBlackjackBet}}
+	if natural {
+		changes = append(changes, BalanceChange{ID: key.UserID, Amount: new(big.Int).Add(bet, payout), Reason: reasonBlackjackNatural})
+	}
+	balances, err := store.Transact(changes...)
+	if err == ErrInsufficientFunds {
+		blackjackSessions.End(key)
+		finishGame(logFor(ctx), r, "cancelled")
+		ctx.Reply(ctx.Author().Mention + " you no longer have enough money for that bet.")
+		return nil
+	}
+	if err != nil {
+		blackjackSessions.End(key)
+		finishGame(logFor(ctx), r, "cancelled")
+		return fmt.Errorf("could not take blackjack bet of %s: %w", key.UserID, err)
+	}
+
+	if natural {
+		blackjackSessions.End(key)
+		finishGame(logFor(ctx), r, blackjackResult(game, reasonBlackjackNatural))
+		bal := balances[len(balances)-1]
+		ctx.ReplyEmbed(&Embed{
+			Color: 0x00ff00,
+			Fields: append(append(game.fields(true, nil), EmbedField{
+				Name:   "Result",
+				Value:  "You got a blackjack! You now have " + bal.String() + " (" + payout.String() + ").",
+				Inline: false,
+			}), gameFields(r)...),
+			Title: "Blackjack - You won!",
+		})
+		addStat(logFor(ctx), ctx.Author().ID, "bj_wins", 1)
+		return nil
+	}
+
+	// Send the hands with buttons for the moves the player can make
+	msg, err := ctx.ReplyEmbed(&Embed{
+		Color:  0xffff00,
+		Fields: append(game.fields(false, nil), gameFields(r)...),
+		Title:  "Blackjack",
+	}, game.buttons()...)
+
+	if err != nil {
+		// The game never started, so the bet is returned
+		blackjackSessions.End(key)
+		finishGame(logFor(ctx), r, "cancelled")
+		_, rerr := addBalance(key.UserID, bet, reasonBlackjackRefund)
+		if rerr != nil {
+			logFor(ctx).Error("Could not refund blackjack bet", "error", rerr)
+		}
+		return fmt.Errorf("could not send blackjack game: %w", err)
+	}
+
+	blackjackSessions.With(key, func(data interface{}) bool {
+		game.msg = msg
+		if peeked {
+			var end bool
+			end, err = blackjackSettle(ctx, key.UserID, game)
+			if end {
+				return true
+			}
+		}
+		saveBlackjackGame(logFor(ctx), key.UserID, game)
+		return false
+	})
+	return err
+}
+
+func blackjackCont(ctx Context, buttonID string, msg MessageRef) error {
+	id := ctx.Author().ID
+	mine := false
+	var err error
+	// The session stays locked until the move is done, so that clicking twice cannot settle a game twice
+	blackjackSessions.With(sessionKey{UserID: id}, func(data interface{}) bool {
+		game := data.(*blackjackGame)
+		if msg.MessageID != game.msg.MessageID {
+			return false
+		}
+		mine = true
+		game.time = time.Now().Unix()
+		var end bool
+		end, err = blackjackMove(ctx, id, game, buttonID)
+		return end
+	})
+	if !mine {
+		ctx.Reply("This is not your game!")
+	}
+	return err
+}
+
+// blackjackMove plays the button the player clicked on the hand being played and reports whether the game is over.
+// The game is kept going if an error occurs so that the player can try again.
+func blackjackMove(ctx Context, id string, game *blackjackGame, buttonID string) (bool, error) {
+	if game.offering {
+		return blackjackInsure(ctx, id, game, buttonID)
+	}
+	hand := game.playing()
+	if hand == nil {
+		// Every hand is done but settling the game failed, any move settles it again
+		return blackjackSettle(ctx, id, game)
+	}
+
+	switch buttonID {
+
+	case "bj_hit":
+		if !game.canHit(hand) {
+			return false, nil
+		}
+		hand.Cards = append(hand.Cards, getRandomCard(&game.deck, game.rng))
+		if game.rules.Classic && game.rules.dealerHits(game.dealer) {
+			game.dealer = append(game.dealer, getRandomCard(&game.deck, game.rng))
+			if getHandTotal(&game.dealer) > 21 {
+				// The dealer busted, so every hand is settled as it is
+				return blackjackSettle(ctx, id, game)
+			}
+		}
+
+	case "bj_stand":
+		hand.Stood = true
+
+	case "bj_double":
+		if !game.canDouble(hand) {
+			return false, nil
+		}
+		// The extra stake is taken like the bet, so that the payout only has to return the doubled bet
+		_, err := store.Transact(BalanceChange{ID: id, Amount: new(big.Int).Neg(hand.Bet), Reason: reasonBlackjackDouble})
+		if err == ErrInsufficientFunds {
+			ctx.Reply(ctx.Author().Mention + " you need another $" + hand.Bet.String() + " to double down.")
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("could not take blackjack double down of %s: %w", id, err)
+		}
+		hand.Bet = new(big.Int).Lsh(hand.Bet, 1)
+		hand.Doubled = true
+		hand.Cards = append(hand.Cards, getRandomCard(&game.deck, game.rng))
+
+	case "bj_split":
+		if !game.canSplit(hand) {
+			return false, nil
+		}
+		// The new hand is bet as much as the hand it was split from
+		_, err := store.Transact(BalanceChange{ID: id, Amount: new(big.Int).Neg(hand.Bet), Reason: reasonBlackjackSplit})
+		if err == ErrInsufficientFunds {
+			ctx.Reply(ctx.Author().Mention + " you need another $" + hand.Bet.String() + " to split.")
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("could not take blackjack split of %s: %w", id, err)
+		}
+		aces := hand.Cards[0] == "A"
+		split := &blackjackHand{Cards: []string{hand.Cards[1]}, Bet: new(big.Int).Set(hand.Bet), Split: true, SplitAces: aces}
+		hand.Cards = []string{hand.Cards[0]}
+		hand.Split = true
+		hand.SplitAces = aces
+		game.hands = append(game.hands[:game.current+1], append([]*blackjackHand{split}, game.hands[game.current+1:]...)...)
+		// Each hand is dealt its second card right away
+		hand.Cards = append(hand.Cards, getRandomCard(&game.deck, game.rng))
+		split.Cards = append(split.Cards, getRandomCard(&game.deck, game.rng))
+
+	case "bj_forfeit":
+		// The bet was already taken when the game started, this only records the forfeit in the ledger
+		bal, err := addBalance(id, big.NewInt(0), reasonBlackjackForfeit)
+		if err != nil {
+			return false, err
+		}
+		addStat(logFor(ctx), id, "bj_losses", len(game.hands))
+
+		ctx.Edit(game.msg, Response{
+			Embed: &Embed{
+				Color: 0x00ff00,
+				Fields: append(append(game.fields(true, nil), EmbedField{
+					Name:   "Result",
+					Value:  "You forfeited, You now have " + bal.String() + "(-" + game.staked().String() + ").",
+					Inline: false,
+				}), gameFields(game.rng)...),
+				Title: "Blackjack - You forfeited",
+			},
+		})
+		endBlackjackGame(logFor(ctx), id, game, reasonBlackjackForfeit)
+		return true, nil
+
+	default:
+		return false, nil
+	}
+
+	if game.advance() {
+		return blackjackSettle(ctx, id, game)
+	}
+	game.update(ctx)
+	saveBlackjackGame(logFor(ctx), id, game)
+	return false, nil
+}
+
+// blackjackInsure takes or declines the insurance or even money offered when the dealer shows an ace.
+// The dealer's hole card is checked once the player decided, play only continues if it is not a blackjack.
+func blackjackInsure(ctx Context, id string, game *blackjackGame, buttonID string) (bool, error) {
+	bet := game.hands[0].Bet
+	switch buttonID {
+
+	case "bj_insurance":
+		if game.natural() {
+			return false, nil
+		}
+		side := game.insuranceBet()
+		if side.Sign() != 1 {
+			ctx.Reply(ctx.Author().Mention + " your bet is too small to insure.")
+			return false, nil
+		}
+		// The side bet is settled right away, it is taken along with what it pays so that it is always covered
+		changes := []BalanceChange{{ID: id, Amount: new(big.Int).Neg(side), Reason: reasonBlackjackInsurance}}
+		if game.dealerNatural() {
+			changes = append(changes, BalanceChange{ID: id, Amount: new(big.Int).Mul(side, big.NewInt(3)), Reason: reasonBlackjackInsuranceWin})
+		}
+		_, err := store.Transact(changes...)
+		if err == ErrInsufficientFunds {
+			ctx.Reply(ctx.Author().Mention + " you need another $" + side.String() + " to take insurance.")
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("could not take blackjack insurance of %s: %w", id, err)
+		}
+		if game.dealerNatural() {
+			addStat(logFor(ctx), id, "bj_insurance_wins", 1)
+			game.insurance = "Won " + new(big.Int).Lsh(side, 1).String() + ", the dealer has a blackjack."
+		} else {
+			addStat(logFor(ctx), id, "bj_insurance_losses", 1)
+			game.insurance = "Lost " + side.String() + ", the dealer does not have a blackjack."
+		}
+
+	case "bj_even_money":
+		if !game.natural() {
+			return false, nil
+		}
+		// The bet was taken when the game started, so it is returned along with an equal payout
+		bal, err := addBalance(id, new(big.Int).Lsh(bet, 1), reasonBlackjackEvenMoney)
+		if err != nil {
+			return false, err
+		}
+		addStat(logFor(ctx), id, "bj_wins", 1)
+		game.offering = false
+		ctx.Edit(game.msg, Response{
+			Embed: &Embed{
+				Color: 0x00ff00,
+				Fields: append(append(game.fields(true, nil), EmbedField{
+					Name:   "Result",
+					Value:  "You took even money, You now have " + bal.String() + " (" + bet.String() + ").",
+					Inline: false,
+				}), gameFields(game.rng)...),
+				Title: "Blackjack - You won!",
+			},
+		})
+		endBlackjackGame(logFor(ctx), id, game, reasonBlackjackEvenMoney)
+		return true, nil
+
+	case "bj_decline":
+
+	default:
+		return false, nil
+	}
+
+	game.offering = false
+	if game.dealerNatural() {
+		// Every hand is done, the dealer's blackjack beats anything but a blackjack
+		game.current = len(game.hands)
+	}
+	if game.advance() {
+		return blackjackSettle(ctx, id, game)
+	}
+	game.update(ctx)
+	saveBlackjackGame(logFor(ctx), id, game)
+	return false, nil
+}
+
+// handMultiplier returns how many times its bet the hand wins against the dealer's hand, -1 if it loses.
+// A bust always loses and only a blackjack pays 3:2, unless the game is played by the classic rules.
+func handMultiplier(hand *blackjackHand, dealer []string, rules blackjackRules) *big.Rat {
+	if rules.Classic {
+		return classicMultiplier(hand, dealer)
+	}
+	p := getHandTotal(&hand.Cards)
+	d := getHandTotal(&dealer)
+	natural := !hand.Split && len(hand.Cards) == 2 && p == 21
+	dealerNatural := len(dealer) == 2 && d == 21
+	switch {
+	case p > 21:
+		return big.NewRat(-1, 1)
+	case natural && dealerNatural:
+		return big.NewRat(0, 1)
+	case natural:
+		return big.NewRat(3, 2)
+	case dealerNatural:
+		return big.NewRat(-1, 1)
+	case d > 21 || p > d:
+		return big.NewRat(1, 1)
+	case p == d:
+		return big.NewRat(0, 1)
+	}
+	return big.NewRat(-1, 1)
+}
+
+// classicMultiplier settles the hand by checkHands, under which busting along with the dealer is a tie and any 21 or
+// five card hand pays 3:2.
+func classicMultiplier(hand *blackjackHand, dealer []string) *big.Rat {
+	win, mult := checkHands(&hand.Cards, &dealer)
+	if mult == nil {
+		if getHandTotal(&hand.Cards) == getHandTotal(&dealer) {
+			mult = big.NewRat(0, 1)
+		} else {
+			win = false
+		}
+	}
+	if !win {
+		return big.NewRat(-1, 1)
+	}
+	// A split hand cannot have a blackjack, so any of its wins is paid 1:1
+	if hand.Split && mult.Cmp(big.NewRat(1, 1)) > 0 {
+		return big.NewRat(1, 1)
+	}
+	return mult
+}
+
+// blackjackSettle ends the game once every hand of the player is done, the dealer draws and each hand is paid.
+func blackjackSettle(ctx Context, id string, game *blackjackGame) (bool, error) {
+	// The dealer does not draw against a blackjack, which only a dealer blackjack ties, nor against hands that all
+	// busted and lose either way
+	for !game.natural() && !(game.busted() && !game.rules.Classic) && game.rules.dealerHits(game.dealer) {
+		game.dealer = append(game.dealer, getRandomCard(&game.deck, game.rng))
+	}
+
+	payout := new(big.Int)
+	outcomes := make([]string, len(game.hands))
+	var wins, losses int
+	for i, hand := range game.hands {
+		mult := handMultiplier(hand, game.dealer, game.rules)
+		// Payout of bet * multiplier
+		handPayout := ratOf(hand.Bet, mult)
+		payout.Add(payout, handPayout)
+		switch mult.Sign() {
+		case 1:
+			wins++
+			outcomes[i] = "Won " + handPayout.String()
+		case 0:
+			outcomes[i] = "Tied"
+		default:
+			losses++
+			outcomes[i] = "Lost " + hand.Bet.String()
+		}
+	}
+
+	result := "You lost"
+	color := 0xff0000
+	switch payout.Sign() {
+	case 1:
+		result = "You won"
+		color = 0x00ff00
+	case 0:
+		result = "You tied"
+		color = 0xffff00
+	}
+	// The bets were taken as they were placed, so they are returned along with the payout
+	bal, err := addBalance(id, new(big.Int).Add(game.staked(), payout), blackjackReason(payout))
+	if err != nil {
+		return false, err
+	}
+	addStat(logFor(ctx), id, "bj_wins", wins)
+	addStat(logFor(ctx), id, "bj_losses", losses)
+	ctx.Edit(game.msg, Response{
+		Embed: &Embed{
+			Color: color,
+			Fields: append(append(game.fields(true, outcomes), EmbedField{
+				Name:   "Result",
+				Value:  result + ", You now have " + bal.String() + " (" + payout.String() + ").",
+				Inline: false,
+			}), gameFields(game.rng)...),
+			Title: "Blackjack - " + result,
+		},
+	})
+	endBlackjackGame(logFor(ctx), id, game, blackjackReason(payout))
+	return true, nil
+}
+
+// blackjackState is the part of a blackjack game that is persisted as the game's state.
+type blackjackState struct {
+	Deck    map[string]int   `json:"deck"`
+	Player  []*blackjackHand `json:"player"`
+	Current int              `json:"current"`
+	Dealer  []string         `json:"dealer"`
+	// Offering and Insurance are the insurance offered to the player and how the insurance they took did.
+	Offering  bool   `json:"offering,omitempty"`
+	Insurance string `json:"insurance,omitempty"`
+	// Rules is the name of the game's rules.
+	Rules string `json:"rules"`
+	// FairGame or Seed is set depending on the rng of the game, which is restored by replaying its Rolls.
+	FairGame int64  `json:"fair_game,omitempty"`
+	Seed     *int64 `json:"seed,omitempty"`
+	Rolls    string `json:"rolls,omitempty"`
+}
+
+// Policies for blackjack games that were interrupted by a restart.
+const (
+	restartResume  = "resume"
+	restartRefund  = "refund"
+	restartForfeit = "forfeit"
+)
+
+func validRestartPolicy(policy string) bool {
+	return policy == restartResume || policy == restartRefund || policy == restartForfeit
+}
+
+func saveBlackjackGame(l logger, id string, game *blackjackGame) {
+	st := blackjackState{
+		Deck:      game.deck,
+		Player:    game.hands,
+		Current:   game.current,
+		Dealer:    game.dealer,
+		Offering:  game.offering,
+		Insurance: game.insurance,
+		Rules:     game.rules.Name,
+	}
+	switch r := game.rng.(type) {
+	case *fairRNG:
+		st.FairGame = r.game.ID
+		st.Rolls = formatRolls(r.rolls)
+	case *seededRNG:
+		st.Seed = &r.seed
+		st.Rolls = formatRolls(r.rolls)
+	}
+	state, err := json.Marshal(st)
+	if err == nil {
+		err = store.SaveGame(SavedGame{
+			UserID:     id,
+			Kind:       "blackjack",
+			State:      state,
+			Bet:        game.staked(),
+			Msg:        game.msg,
+			LastActive: game.time,
+		})
+	}
+	if err != nil {
+		l.Error("Could not save blackjack game", "error", err)
+	}
+}
+
+// endBlackjackGame records the outcome of the game once it is over and deletes the saved game.
+func endBlackjackGame(l logger, id string, game *blackjackGame, outcome string) {
+	finishGame(l, game.rng, blackjackResult(game, outcome))
+	err := store.DeleteGame(id, "blackjack")
+	if err != nil {
+		l.Error("Could not delete blackjack game", "error", err)
+	}
+}
+
+// restoreBlackjackGames resumes or settles the games that were in progress when the bot stopped,
+// depending on the configured restart policy.
+func restoreBlackjackGames() {
+	games, err := store.Games("blackjack")
+	if err != nil {
+		rootLogger.Error("Could not load blackjack games", "error", err)
+		return
+	}
+	for _, saved := range games {
+		l := rootLogger.With("user_id", saved.UserID)
+		var state blackjackState
+		err := json.Unmarshal(saved.State, &state)
+		policy := config.BlackjackRestart
+		if err != nil || len(state.Player) == 0 || len(state.Dealer) == 0 || state.Deck == nil {
+			l.Warn("Could not restore blackjack game, refunding it", "error", err)
+			policy = restartRefund
+			state.Player = []*blackjackHand{{Cards: []string{}, Bet: saved.Bet}}
+			state.Dealer = []string{"?"}
+		}
+		game := &blackjackGame{
+			deck:      state.Deck,
+			hands:     state.Player,
+			current:   state.Current,
+			dealer:    state.Dealer,
+			msg:       saved.Msg,
+			time:      time.Now().Unix(),
+			offering:  state.Offering,
+			insurance: state.Insurance,
+		}
+		game.rules = blackjackPreset(config.BlackjackRules)
+		if validBlackjackRules(state.Rules) {
+			game.rules = blackjackPreset(state.Rules)
+		}
+		var rerr error
+		game.rng, rerr = restoreBlackjackRNG(state)
+		if rerr != nil && policy == restartResume {
+			l.Warn("Could not restore rng of blackjack game, refunding it", "error", rerr)
+			policy = restartRefund
+		}
+
+		var result string
+		var outcome string
+		switch policy {
+		case restartResume:
+			blackjackSessions.Start(sessionKey{UserID: saved.UserID}, game)
+			saveBlackjackGame(l, saved.UserID, game)
+			responder.Edit(game.msg, Response{
+				Embed: &Embed{
+					Color: 0xffff00,
+					Fields: append(game.fields(false, nil), EmbedField{
+						Name:   "Restarted",
+						Value:  "The bot restarted during this game, it has been resumed.",
+						Inline: false,
+					}),
+					Title: "Blackjack",
+				},
+				Buttons: game.buttons(),
+			})
+			continue
+		case restartRefund:
+			outcome = reasonBlackjackRefund
+			_, err := addBalance(saved.UserID, saved.Bet, reasonBlackjackRefund)
+			if err != nil {
+				// The game is kept so that it is settled on the next start instead
+				l.Error("Could not refund blackjack game", "error", err)
+				continue
+			}
+			result = "The bot restarted during this game, your bet of " + saved.Bet.String() + " has been refunded."
+		case restartForfeit:
+			outcome = reasonBlackjackForfeit
+			// The bet was already taken when the game started, this only records the forfeit in the ledger
+			_, err := addBalance(saved.UserID, big.NewInt(0), reasonBlackjackForfeit)
+			if err != nil {
+				l.Error("Could not forfeit blackjack game", "error", err)
+				continue
+			}
+			addStat(l, saved.UserID, "bj_losses", len(game.hands))
+			result = "The bot restarted during this game, your bet of " + saved.Bet.String() + " was lost."
+		}
+		responder.Edit(game.msg, Response{
+			Embed: &Embed{
+				Color: 0xff0000,
+				Fields: append(game.fields(true, nil), EmbedField{
+					Name:   "Result",
+					Value:  result,
+					Inline: false,
+				}),
+				Title: "Blackjack - Restarted",
+			},
+		})
+		endBlackjackGame(l, saved.UserID, game, outcome)
+	}
+}
+
+// restoreBlackjackRNG recreates the rng of a saved game so that it continues where it left off.
+func restoreBlackjackRNG(state blackjackState) (rng, error) {
+	rolls, err := parseRolls(state.Rolls)
+	switch {
+	case state.FairGame != 0:
+		game, ferr := store.FairGame(state.FairGame)
+		if ferr != nil {
+			return newSeededRNG(time.Now().UnixNano()), ferr
+		}
+		if err != nil {
+			return newFairRNG(game), err
+		}
+		return replayFairRNG(game, rolls)
+	case state.Seed != nil:
+		r := newSeededRNG(*state.Seed)
+		if err != nil {
+			return r, err
+		}
+		return r, replayRolls(r, rolls)
+	}
+	return newSeededRNG(time.Now().UnixNano()), errors.New("the rng of the game was not recorded")
+}
+
+// blackjackResult describes the outcome and hands of a game for its provably fair record.
+func blackjackResult(game *blackjackGame, outcome string) string {
+	hands := make([]string, len(game.hands))
+	for i, hand := range game.hands {
+		hands[i] = strings.Join(hand.Cards, " ") + " (" + strconv.Itoa(getHandTotal(&hand.Cards)) + ")"
+	}
+	return outcome + ", player " + strings.Join(hands, " / ") +
+		", dealer " + strings.Join(game.dealer, " ") + " (" + strconv.Itoa(getHandTotal(&game.dealer)) + ")"
+}
+
+// blackjackReason returns the ledger reason for a game that ended with the given net payout.
+func blackjackReason(payout *big.Int) string {
+	switch payout.Sign() {
+	case 1:
+		return reasonBlackjackWin
+	case 0:
+		return reasonBlackjackPush
+	}
+	return reasonBlackjackLoss
+}
+
+func getRandomCard(deck *map[string]int, r rng) string {
+	for {
+		card := cardTypes[r.Intn(len(cardTypes))]
+		if (*deck)[card] > 0 {
+			(*deck)[card]--
+			return card
+		}
+	}
+}
+
+func generateHandString(hand *[]string) string {
+	var handString string
+	for _, card := range *hand {
+		handString += "`" + card + "` "
+	}
+	return handString + "\nTotal: " + strconv.Itoa(getHandTotal(hand))
+}
+
+func getHandTotal(hand *[]string) int {
+	cardValues := map[string]int{
+		"2":  2,
+		"3":  3,
+		"4":  4,
+		"5":  5,
+		"6":  6,
+		"7":  7,
+		"8":  8,
+		"9":  9,
+		"10": 10,
+		"J":  10,
+		"Q":  10,
+		"K":  10,
+	}
+	var total int
+	var aces int
+	// Ace is 11 unless it would make the total go over 21
+	// Due to this, its value should only be calculated after the rest.
+	for _, card := range *hand {
+		if card == "A" {
+			total += 11
+			aces++
+		} else {
+			total += cardValues[card]
+		}
+	}
+	for i := 0; i < aces; i++ {
+		if total > 21 {
+			total -= 10
+		}
+	}
+	return total
+}
+
+// isSoft reports whether the hand's total counts an ace as 11.
+func isSoft(hand *[]string) bool {
+	hard := 0
+	for _, card := range *hand {
+		if card == "A" {
+			hard++
+		} else {
+			hard += getHandTotal(&[]string{card})
+		}
+	}
+	return getHandTotal(hand) != hard
+}
+
+func checkHands(player *[]string, dealer *[]string) (bool, *big.Rat) {
+	// If the player lost, return false
+	// If the the player has a blackjack, a push or has 5 cards without busting, the player wins 1.5x the bet so 1.5 should be returned.
+	// If the player's hand is a bust, the player loses all of his bet so -1 should be returned.
+	p := getHandTotal(player)
+	d := getHandTotal(dealer)
+
+	if p > 21 && d > 21 {
+		return true, big.NewRat(0, 1)
+	}
+
+	if p > 21 {
+		return false, big.NewRat(-1, 1)
+	}
+
+	if d == 21 || (len(*dealer) == 5 && d <= 21) {
+		if p == 21 || (len(*player) == 5 && p <= 21) {
+			return true, big.NewRat(0, 1)
+		}
+		return false, big.NewRat(-1, 1)
+	}
+
+	if p == 21 {
+		return true, big.NewRat(3, 2)
+	}
+
+	if len(*player) == 5 && p <= 21 {
+		return true, big.NewRat(3, 2)
+	}
+
+	if p > d {
+		return true, big.NewRat(1, 1)
+	}
+
+	if d > 21 {
+		return true, big.NewRat(1, 1)
+	}
+
+	if p == d {
+		return true, big.NewRat(0, 1)
+	}
+
+	//	if getHandTotal(player) == getHandTotal(dealer) {
+	//		return true, big.NewFloat(0)
+	//	}
+
+	return true, nil
+}
+
+// https://stackoverflow.com/a/37335777 by T. Claverie under CC BY-SA 4.0
+func remove(s []string, i int) []string {
+	s[i] = s[len(s)-1]
+	return s[:len(s)-1]
+}
+
+// blackjackTimeout ends a game whose player stopped playing, the bet is lost.
+func blackjackTimeout(key sessionKey, data interface{}) {
+	game := data.(*blackjackGame)
+	id := key.UserID
+	l := rootLogger.With("user_id", id)
+	// The bet was already taken when the game started, this only records the timeout in the ledger
+	result := "You timed out. You lost " + game.staked().String()
+	bal, err := addBalance(id, big.NewInt(0), reasonBlackjackTimeout)
+	if err != nil {
+		l.Error("Could not record blackjack timeout", "error", err)
+	} else {
+		result += ", and now have " + bal.String()
+	}
+	responder.Edit(game.msg, Response{
+		Embed: &Embed{
+			Color: 0xff0000,
+			Fields: append(append(game.fields(true, nil), EmbedField{
+				Name:   "Result",
+				Value:  result + ".",
+				Inline: false,
+			}), gameFields(game.rng)...),
+			Title: "Blackjack - Timeout",
+		},
+	})
+	endBlackjackGame(l, id, game, reasonBlackjackTimeout)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// blackjackRules are the dealer's rules of a blackjack game, guilds pick one of the presets.
+type blackjackRules struct {
+	Name        string
+	Description string
+	// StandOn is the lowest total the dealer stands on.
+	StandOn int
+	// HitSoft17 makes the dealer hit a soft 17, a 17 that counts an ace as 11.
+	HitSoft17 bool
+	// Peek makes the dealer check for a blackjack under a ten before the player plays, as they do under an ace.
+	// A dealer blackjack then only takes the bet, not what was doubled or split.
+	Peek bool
+	// Classic keeps the rules from before rule sets: the dealer draws along with the player's hits and is only dealt
+	// a blackjack when showing an ace, busting along with the dealer is a tie and any 21 or five card hand pays 3:2.
+	Classic bool
+}
+
+// Names of the blackjack rule presets.
+const (
+	rulesStandard = "standard"
+	rulesH17      = "h17"
+	rulesClassic  = "classic"
+)
+
+var blackjackPresetNames = []string{rulesStandard, rulesH17, rulesClassic}
+
+func validBlackjackRules(name string) bool {
+	for _, preset := range blackjackPresetNames {
+		if name == preset {
+			return true
+		}
+	}
+	return false
+}
+
+// blackjackPreset returns the rules of the preset, which must be valid.
+// The classic dealer draws at or below config.DealerThreshold.
+func blackjackPreset(name string) blackjackRules {
+	switch name {
+	case rulesH17:
+		return blackjackRules{
+			Name:        rulesH17,
+			Description: "The dealer hits soft 17 and peeks for blackjack.",
+			StandOn:     17,
+			HitSoft17:   true,
+			Peek:        true,
+		}
+	case rulesClassic:
+		return blackjackRules{
+			Name:        rulesClassic,
+			Description: "The dealer draws at " + strconv.Itoa(config.DealerThreshold) + " or below and along with your hits, and only has blackjack when showing an ace. Busting along with the dealer ties and any 21 or five card hand pays 3:2.",
+			StandOn:     config.DealerThreshold + 1,
+			Classic:     true,
+		}
+	}
+	return blackjackRules{
+		Name:        rulesStandard,
+		Description: "The dealer stands on all 17s and peeks for blackjack.",
+		StandOn:     17,
+		Peek:        true,
+	}
+}
+
+// dealerHits reports whether the dealer draws another card to the hand.
+func (rules blackjackRules) dealerHits(hand []string) bool {
+	total := getHandTotal(&hand)
+	return total < rules.StandOn || (rules.HitSoft17 && total == 17 && isSoft(&hand))
+}
+
+// getBlackjackRules returns the rules the guild plays blackjack by, config.BlackjackRules if it did not pick any.
+func getBlackjackRules(guildID string) (blackjackRules, error) {
+	name, err := store.BlackjackRules(guildID)
+	if err != nil {
+		return blackjackRules{}, fmt.Errorf("could not get blackjack rules of server %s: %w", guildID, err)
+	}
+	if !validBlackjackRules(name) {
+		name = config.BlackjackRules
+	}
+	return blackjackPreset(name), nil
+}
+
+// rulesList describes every preset, marking the one in use.
+func rulesList(current string) string {
+	lines := make([]string, len(blackjackPresetNames))
+	for i, name := range blackjackPresetNames {
+		line := "`" + name + "` " + blackjackPreset(name).Description
+		if name == current {
+			line = "**" + line + " (current)**"
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func blackjackRulesCmd(ctx Context, args Args) error {
+	if !args.Has("preset") {
+		rules, err := getBlackjackRules(ctx.GuildID())
+		if err != nil {
+			return err
+		}
+		ctx.ReplyEmbed(&Embed{
+			Color: 0x00ff00,
+			Fields: []EmbedField{
+				{
+					Name:   "Presets",
+					Value:  rulesList(rules.Name),
+					Inline: false,
+				},
+			},
+			Title: "Blackjack rules",
+		})
+		return nil
+	}
+	if !ctx.CanManageGuild() {
+		ctx.Reply(ctx.Author().Mention + " you do not have the necessary permissions to change the blackjack rules (Manage Server).")
+		return nil
+	}
+	name := args.String("preset")
+	err := store.SetBlackjackRules(ctx.GuildID(), name)
+	if err != nil {
+		return fmt.Errorf("could not change blackjack rules of server %s to %s: %w", ctx.GuildID(), name, err)
+	}
+	ctx.Reply("Blackjack is now played by the " + name + " rules: " + blackjackPreset(name).Description +
+		"\nGames in progress keep the rules they started with.")
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// playBlackjack plays a game of the given rules in which the cards are drawn in order, making the moves through
+// blackjackMove like the buttons would, and returns how much the player's balance changed.
+func playBlackjack(t *testing.T, rules string, bet *big.Int, cards []string, moves ...string) *big.Int {
+	t.Helper()
+	setupLogging("error", "stderr")
+	store = newMemoryStore(config.DefaultPrefix)
+	blackjackSessions = newSessionManager(time.Minute, blackjackTimeout)
+	deck := cardSequence(cards...)
+	gameRNGs = rngSourceFunc(func(userID string, kind string) (rng, error) {
+		return deck, nil
+	})
+	defer func() {
+		gameRNGs = fairSource{}
+	}()
+
+	ctx := simContext{userID: "1", sent: new(int)}
+	key := sessionKey{UserID: ctx.userID}
+	start := new(big.Int).Mul(bet, big.NewInt(10))
+	store.CreateUser(ctx.userID, start)
+	err := store.SetBlackjackRules(ctx.GuildID(), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := commandsByAlias["blackjack"].Args.parse([]string{bet.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = blackjack(ctx, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, move := range moves {
+		inProgress := blackjackSessions.With(key, func(data interface{}) bool {
+			var end bool
+			end, err = blackjackMove(ctx, ctx.userID, data.(*blackjackGame), move)
+			return end
+		})
+		if !inProgress {
+			t.Fatalf("game was over before %s", move)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if blackjackSessions.Has(key) {
+		t.Fatal("game is still in progress")
+	}
+	if len(deck.values) > 0 {
+		t.Fatalf("%d cards were not drawn", len(deck.values))
+	}
+
+	bal, err := getBalance(ctx.userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bal.Sub(bal, start)
+}
+
+func TestBlackjackSettle(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		cards []string
+		moves []string
+		want  int64
+	}{
+		{
+			name:  "blackjack pays 3:2",
+			rules: rulesStandard,
+			cards: []string{"10", "9", "A", "K"},
+			want:  150,
+		},
+		{
+			name:  "dealer busts",
+			rules: rulesStandard,
+			cards: []string{"10", "6", "10", "7", "10"},
+			moves: []string{"bj_stand"},
+			want:  100,
+		},
+		{
+			name:  "push",
+			rules: rulesStandard,
+			cards: []string{"10", "8", "10", "8"},
+			moves: []string{"bj_stand"},
+			want:  0,
+		},
+		{
+			name:  "three card 21 pays 1:1",
+			rules: rulesStandard,
+			cards: []string{"10", "7", "5", "6", "10"},
+			moves: []string{"bj_hit"},
+			want:  100,
+		},
+		{
+			name:  "bust loses without the dealer drawing",
+			rules: rulesStandard,
+			cards: []string{"10", "6", "10", "6", "10"},
+			moves: []string{"bj_hit"},
+			want:  -100,
+		},
+		{
+			name:  "dealer five cards are no 21",
+			rules: rulesStandard,
+			cards: []string{"2", "3", "10", "K", "2", "2", "10"},
+			moves: []string{"bj_stand"},
+			want:  100,
+		},
+		{
+			name:  "double down",
+			rules: rulesStandard,
+			cards: []string{"10", "6", "5", "6", "10", "10"},
+			moves: []string{"bj_double"},
+			want:  200,
+		},
+		{
+			name:  "split 21 pays 1:1",
+			rules: rulesStandard,
+			cards: []string{"10", "7", "8", "8", "3", "10", "K"},
+			moves: []string{"bj_split", "bj_hit", "bj_stand"},
+			want:  200,
+		},
+		{
+			name:  "h17 dealer hits soft 17",
+			rules: rulesH17,
+			cards: []string{"A", "6", "10", "8", "2"},
+			moves: []string{"bj_decline", "bj_stand"},
+			want:  -100,
+		},
+		{
+			name:  "five cards can hit on",
+			rules: rulesStandard,
+			cards: []string{"10", "7", "2", "2", "2", "2", "2", "9"},
+			moves: []string{"bj_hit", "bj_hit", "bj_hit", "bj_hit", "bj_stand"},
+			want:  100,
+		},
+		{
+			name:  "classic five cards pay 3:2",
+			rules: rulesClassic,
+			cards: []string{"10", "8", "2", "2", "2", "2", "2"},
+			moves: []string{"bj_hit", "bj_hit", "bj_hit"},
+			want:  150,
+		},
+		{
+			name:  "insurance pays 2:1",
+			rules: rulesStandard,
+			cards: []string{"A", "K", "10", "8"},
+			moves: []string{"bj_insurance"},
+			want:  0,
+		},
+		{
+			name:  "insurance is lost without a dealer blackjack",
+			rules: rulesStandard,
+			cards: []string{"A", "6", "10", "8"},
+			moves: []string{"bj_insurance", "bj_stand"},
+			want:  50,
+		},
+		{
+			name:  "standard dealer stands on soft 17",
+			rules: rulesStandard,
+			cards: []string{"A", "6", "10", "8"},
+			moves: []string{"bj_decline", "bj_stand"},
+			want:  100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := playBlackjack(t, tt.rules, big.NewInt(100), tt.cards, tt.moves...)
+			if got.Cmp(big.NewInt(tt.want)) != 0 {
+				t.Errorf("balance changed by %s, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlackjackNaturalIsExact(t *testing.T) {
+	bet, _ := new(big.Int).SetString("1000000000000000000001", 10)
+	want, _ := new(big.Int).SetString("1500000000000000000001", 10)
+	got := playBlackjack(t, rulesStandard, bet, []string{"10", "9", "A", "K"})
+	if got.Cmp(want) != 0 {
+		t.Errorf("balance changed by %s, want %s", got, want)
+	}
+}
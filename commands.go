@@ -0,0 +1,524 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// permission is what a user needs to be allowed to use a command.
+type permission int
+
+const (
+	permEveryone permission = iota
+	// permManageGuild is held by users who can change the guild's settings.
+	permManageGuild
+	// permAdmin is held by economy admins and owners.
+	permAdmin
+)
+
+func (p permission) String() string {
+	switch p {
+	case permManageGuild:
+		return "Manage Server"
+	case permAdmin:
+		return "Economy Admin"
+	}
+	return "Everyone"
+}
+
+// Categories commands are grouped in by help, in the order they are listed.
+const (
+	categoryGeneral = "General"
+	categoryEconomy = "Economy"
+	categoryGames   = "Games"
+	categoryFair    = "Provably fair"
+	categoryAdmin   = "Admin"
+)
+
+var categories = []string{categoryGeneral, categoryEconomy, categoryGames, categoryFair, categoryAdmin}
+
+// command is everything there is to know about a command. Dispatch, help, aliases and the slash commands are all
+// derived from it.
+type command struct {
+	// Name is the name the command is listed and registered under.
+	Name string
+	// Aliases are the other names the command can be invoked by.
+	Aliases     []string
+	Description string
+	Category    string
+	Args        signature
+	Permission  permission
+	// ServerTier is the tier the server must have for the command to be used in it, such as serverPremium.
+	// Commands without one can be used in any server.
+	ServerTier string
+	// Economy commands cannot be used by users banned from the economy.
+	Economy bool
+	// Cooldown is the cooldown the command puts users on, if any. See cooldownNames.
+	Cooldown string
+	// Examples are invocations of the command without the prefix.
+	Examples []string
+	Run      handler
+}
+
+// middleware returns the middleware the command needs, they run after globalMiddleware and once the arguments are
+// parsed.
+func (c *command) middleware() []middleware {
+	mws := c.guards()
+	if c.Cooldown != "" {
+		mws = append(mws, cooldown(c.Cooldown))
+	}
+	return mws
+}
+
+// guards returns the middleware that decides who may use the command where, which the buttons of its messages go
+// through as well.
+func (c *command) guards() []middleware {
+	mws := make([]middleware, 0, 4)
+	if c.ServerTier != "" && c.ServerTier != serverDefault {
+		mws = append(mws, requireServerTier(c.ServerTier))
+	}
+	switch c.Permission {
+	case permManageGuild:
+		mws = append(mws, requireManageGuild)
+	case permAdmin:
+		mws = append(mws, requireAdmin)
+	}
+	if c.Economy {
+		mws = append(mws, economy)
+	}
+	return mws
+}
+
+// commands are all the commands in the order help lists them within their category.
+var commands []*command
+
+// commandsByAlias maps the name and every alias of each command to it.
+var commandsByAlias = make(map[string]*command)
+
+// registerCommands adds the commands to the registry. It panics if an alias is taken twice, so that a command can
+// never silently shadow another.
+func registerCommands(cmds ...*command) {
+	for _, cmd := range cmds {
+		for _, alias := range append([]string{cmd.Name}, cmd.Aliases...) {
+			if other, taken := commandsByAlias[alias]; taken {
+				panic("alias " + alias + " of " + cmd.Name + " is taken by " + other.Name)
+			}
+			commandsByAlias[alias] = cmd
+		}
+		if cmd.Cooldown != "" && !validCooldown(cmd.Cooldown) {
+			panic("unknown cooldown " + cmd.Cooldown + " of " + cmd.Name)
+		}
+		if cmd.ServerTier != "" && !validServerTier(cmd.ServerTier) {
+			panic("unknown server tier " + cmd.ServerTier + " of " + cmd.Name)
+		}
+		commands = append(commands, cmd)
+		slashCommandNames[slashName(cmd)] = cmd.Name
+	}
+}
+
+// The commands are registered in init since help and aliases read the registry themselves.
+func init() {
+	bet := param{Name: "bet", Kind: argAmount, Description: "The amount to bet, such as 500, 2.5k, 10% or all"}
+	optionalBet := bet
+	optionalBet.Optional = true
+
+	registerCommands(
+		&command{
+			Name:        "commands",
+			Aliases:     []string{"help", "h", "cmds", "cmd"},
+			Description: "Displays a list of commands, or how to use one of them.",
+			Category:    categoryGeneral,
+			Args: signature{
+				{Name: "command", Kind: argCommand, Optional: true, Description: "The command to show how to use"},
+				{Name: "page", Kind: argPage, Optional: true, Description: "The page of the list of commands"},
+			},
+			Examples: []string{"help", "help 2", "help blackjack"},
+			Run:      help,
+		},
+		&command{
+			Name:        "prefix",
+			Description: "Shows or sets the current prefix.",
+			Category:    categoryGeneral,
+			Args:        signature{{Name: "prefix", Kind: argString, Optional: true, Description: "The new prefix"}},
+			Permission:  permManageGuild,
+			Examples:    []string{"prefix", "prefix !"},
+			Run:         prefix,
+		},
+		&command{
+			Name:        "aliases",
+			Aliases:     []string{"alternatives", "alt", "alts"},
+			Description: "Shows all aliases for the command.",
+			Category:    categoryGeneral,
+			Args:        signature{{Name: "command", Kind: argCommand, Description: "The command to look for aliases for"}},
+			Examples:    []string{"aliases balance"},
+			Run:         alts,
+		},
+		&command{
+			Name:        "balance",
+			Aliases:     []string{"bal", "money"},
+			Description: "Displays the amount of money the user has.",
+			Category:    categoryEconomy,
+			Args:        signature{{Name: "user", Kind: argUser, Optional: true, Description: "The user whose balance to show"}},
+			Economy:     true,
+			Cooldown:    "balance",
+			Examples:    []string{"balance", "bal @user"},
+			Run:         balance,
+		},
+		&command{
+			Name:        "daily",
+			Aliases:     []string{"d"},
+			Description: "Claim your daily supply of money.",
+			Category:    categoryEconomy,
+			Economy:     true,
+			Examples:    []string{"daily"},
+			Run:         daily,
+		},
+		&command{
+			Name:        "top",
+			Aliases:     []string{"leaderboard", "lb"},
+			Description: "Shows the top players.",
+			Category:    categoryEconomy,
+			Args:        signature{{Name: "page", Kind: argPage, Optional: true, Description: "The page of the leaderboard"}},
+			Economy:     true,
+			Cooldown:    "top",
+			Examples:    []string{"top", "lb 2"},
+			Run:         top,
+		},
+		&command{
+			Name:        "stats",
+			Description: "Shows the user's stats.",
+			Category:    categoryEconomy,
+			Args:        signature{{Name: "user", Kind: argUser, Optional: true, Description: "The user whose stats to show"}},
+			Economy:     true,
+			Cooldown:    "stats",
+			Examples:    []string{"stats", "stats @user"},
+			Run:         stats,
+		},
+		&command{
+			Name:        "history",
+			Aliases:     []string{"transactions", "ledger"},
+			Description: "Shows the user's transaction history.",
+			Category:    categoryEconomy,
+			Args: signature{
+				{Name: "user", Kind: argUser, Optional: true, Description: "The user whose transactions to show"},
+				{Name: "page", Kind: argPage, Optional: true, Description: "The page of the history"},
+			},
+			Economy:  true,
+			Cooldown: "history",
+			Examples: []string{"history", "history 2", "history @user 3"},
+			Run:      history,
+		},
+		&command{
+			Name:        "share",
+			Aliases:     []string{"give", "gift"},
+			Description: "Shares coins with the user.",
+			Category:    categoryEconomy,
+			Args: signature{
+				{Name: "amount", Kind: argAmount, Description: "The amount to share, such as 500, 2.5k, 10% or all"},
+				{Name: "user", Kind: argUser, Description: "The user to share with"},
+			},
+			Economy:  true,
+			Cooldown: "share",
+			Examples: []string{"share 500 @user", "give 10% @user"},
+			Run:      share,
+		},
+		&command{
+			Name:        "blackjack",
+			Aliases:     []string{"bj"},
+			Description: "Play a game of blackjack.",
+			Category:    categoryGames,
+			Args:        signature{bet},
+			Economy:     true,
+			Cooldown:    "blackjack",
+			Examples:    []string{"blackjack 500", "bj 2.5k", "bj half"},
+			Run:         blackjack,
+		},
+		&command{
+			Name:        "blackjackrules",
+			Aliases:     []string{"bjrules"},
+			Description: "Shows the blackjack rules of the server, users who can manage it can pick other rules.",
+			Category:    categoryGames,
+			Args:        signature{{Name: "preset", Kind: argChoice, Optional: true, Choices: blackjackPresetNames, Description: "The rules to play by"}},
+			Examples:    []string{"blackjackrules", "bjrules h17"},
+			Run:         blackjackRulesCmd,
+		},
+		&command{
+			Name:        "50/50",
+			Aliases:     []string{"fiftyfifty", "5050"},
+			Description: "50% chance of winning, how lucky are you?",
+			Category:    categoryGames,
+			Args:        signature{optionalBet},
+			Economy:     true,
+			Cooldown:    "fiftyfifty",
+			Examples:    []string{"50/50", "5050 1k", "50/50 all"},
+			Run:         fiftyfifty,
+		},
+		&command{
+			Name:        "seed",
+			Aliases:     []string{"seeds"},
+			Description: "Shows your provably fair seeds or sets your client seed.",
+			Category:    categoryFair,
+			Args:        signature{{Name: "client seed", Kind: argText, Optional: true, Description: "Your new client seed"}},
+			Economy:     true,
+			Examples:    []string{"seed", "seed lucky charm"},
+			Run:         seed,
+		},
+		&command{
+			Name:        "verify",
+			Description: "Reveals the seeds of a game so that you can check its result.",
+			Category:    categoryFair,
+			Args:        signature{{Name: "game id", Kind: argString, Description: "The ID of the game to verify"}},
+			Economy:     true,
+			Examples:    []string{"verify 42"},
+			Run:         verify,
+		},
+		&command{
+			Name:        "tier",
+			Aliases:     []string{"rank"},
+			Description: "Shows the user's tier, bot owners can change it.",
+			Category:    categoryGeneral,
+			Args: signature{
+				{Name: "user", Kind: argUser, Optional: true, Description: "The user whose tier to show or change"},
+				{Name: "tier", Kind: argChoice, Optional: true, Choices: []string{"default", "owner", "admin", "banned"}, Description: "The new tier"},
+			},
+			Examples: []string{"tier", "tier @user", "tier @user admin"},
+			Run:      tier,
+		},
+		&command{
+			Name:        "servertier",
+			Description: "Shows the server's tier, bot owners can change it.",
+			Category:    categoryGeneral,
+			Args:        signature{{Name: "tier", Kind: argChoice, Optional: true, Choices: []string{"default", "premium"}, Description: "The new tier"}},
+			Examples:    []string{"servertier", "servertier premium"},
+			Run:         servertier,
+		},
+		&command{
+			Name:        "setbal",
+			Description: "Sets the user's balance.",
+			Category:    categoryAdmin,
+			Args: signature{
+				{Name: "user", Kind: argUser, Description: "The user whose balance to set"},
+				{Name: "amount", Kind: argString, Description: "The new balance"},
+				{Name: "reason", Kind: argText, Description: "Why the balance is being set"},
+			},
+			Permission: permAdmin,
+			Examples:   []string{"setbal @user 10k refund for lost game"},
+			Run:        setbal,
+		},
+		&command{
+			Name:        "addbal",
+			Description: "Adds to the user's balance, negative amounts take money away.",
+			Category:    categoryAdmin,
+			Args: signature{
+				{Name: "user", Kind: argUser, Description: "The user whose balance to change"},
+				{Name: "amount", Kind: argString, Description: "The amount to add, negative to take money away"},
+				{Name: "reason", Kind: argText, Description: "Why the balance is being changed"},
+			},
+			Permission: permAdmin,
+			Examples:   []string{"addbal @user 500 event prize", "addbal @user -1k duplicate daily"},
+			Run:        addbal,
+		},
+		&command{
+			Name:        "resetstats",
+			Description: "Resets the user's stats.",
+			Category:    categoryAdmin,
+			Args: signature{
+				{Name: "user", Kind: argUser, Description: "The user whose stats to reset"},
+				{Name: "reason", Kind: argText, Description: "Why the stats are being reset"},
+			},
+			Permission: permAdmin,
+			Examples:   []string{"resetstats @user requested"},
+			Run:        resetstats,
+		},
+		&command{
+			Name:        "resetuser",
+			Description: "Resets the user's balance, stats and daily.",
+			Category:    categoryAdmin,
+			Args: signature{
+				{Name: "user", Kind: argUser, Description: "The user to reset"},
+				{Name: "reason", Kind: argText, Description: "Why the user is being reset"},
+			},
+			Permission: permAdmin,
+			Examples:   []string{"resetuser @user exploited a bug"},
+			Run:        resetuser,
+		},
+		&command{
+			Name:        "economy-ban",
+			Aliases:     []string{"eban"},
+			Description: "Bans the user from the economy.",
+			Category:    categoryAdmin,
+			Args: signature{
+				{Name: "user", Kind: argUser, Description: "The user to ban"},
+				{Name: "reason", Kind: argText, Description: "Why the user is being banned"},
+			},
+			Permission: permAdmin,
+			Examples:   []string{"economy-ban @user alt account"},
+			Run:        economyBan,
+		},
+		&command{
+			Name:        "economy-unban",
+			Aliases:     []string{"eunban"},
+			Description: "Lifts the user's economy ban.",
+			Category:    categoryAdmin,
+			Args: signature{
+				{Name: "user", Kind: argUser, Description: "The user to unban"},
+				{Name: "reason", Kind: argText, Description: "Why the user is being unbanned"},
+			},
+			Permission: permAdmin,
+			Examples:   []string{"economy-unban @user appeal accepted"},
+			Run:        economyUnban,
+		},
+	)
+}
+
+// lookupCommand returns the command with the name or alias.
+func lookupCommand(alias string) (*command, bool) {
+	cmd, exists := commandsByAlias[alias]
+	return cmd, exists
+}
+
+func validCmd(name string) bool {
+	_, exists := lookupCommand(name)
+	return exists
+}
+
+// commandName returns the name of the command the alias belongs to.
+func commandName(alias string) string {
+	if cmd, exists := lookupCommand(alias); exists {
+		return cmd.Name
+	}
+	return alias
+}
+
+const helpPageSize = 10
+
+// helpPages splits the commands into pages of at most helpPageSize, in the order of their categories.
+// A category only starts a new page if it does not fit on the current one, and is only split if it is larger than a
+// page itself.
+func helpPages() [][]*command {
+	pages := [][]*command{{}}
+	for _, category := range categories {
+		cmds := make([]*command, 0)
+		for _, cmd := range commands {
+			if cmd.Category == category {
+				cmds = append(cmds, cmd)
+			}
+		}
+		for len(cmds) > 0 {
+			last := len(pages) - 1
+			room := helpPageSize - len(pages[last])
+			if room < len(cmds) && len(pages[last]) > 0 {
+				pages = append(pages, []*command{})
+				continue
+			}
+			n := len(cmds)
+			if n > room {
+				n = room
+			}
+			pages[last] = append(pages[last], cmds[:n]...)
+			cmds = cmds[n:]
+		}
+	}
+	return pages
+}
+
+func help(ctx Context, args Args) error {
+	prefix, err := getPrefix(ctx.GuildID())
+	if err != nil {
+		return err
+	}
+	if args.Has("command") {
+		cmd, _ := lookupCommand(args.String("command"))
+		ctx.ReplyEmbed(commandHelp(cmd, prefix))
+		return nil
+	}
+
+	pages := helpPages()
+	page := args.Page("page")
+	if page > len(pages) {
+		ctx.Reply(fmt.Sprintf("Exceeded number of pages: %d/%d", page, len(pages)))
+		return nil
+	}
+
+	fields := make([]EmbedField, 0, len(categories))
+	for _, cmd := range pages[page-1] {
+		line := "`" + prefix + cmd.Args.usage(cmd.Name) + "` " + cmd.Description + "\n"
+		if len(fields) > 0 && fields[len(fields)-1].Name == cmd.Category {
+			fields[len(fields)-1].Value += line
+			continue
+		}
+		fields = append(fields, EmbedField{Name: cmd.Category, Value: line, Inline: false})
+	}
+	fields = append(fields, EmbedField{
+		Name:   fmt.Sprintf("Page %d/%d", page, len(pages)),
+		Value:  "Use `" + prefix + "help <command>` to see how to use a command, or `" + prefix + "help <page>` for another page.",
+		Inline: false,
+	})
+	ctx.ReplyEmbed(&Embed{
+		Color:  0xffff00,
+		Fields: fields,
+		Title:  "Commands",
+	})
+	return nil
+}
+
+// commandHelp describes how to use the command.
+func commandHelp(cmd *command, prefix string) *Embed {
+	fields := []EmbedField{
+		{Name: "Description", Value: cmd.Description, Inline: false},
+		{Name: "Usage", Value: "`" + prefix + cmd.Args.usage(cmd.Name) + "`", Inline: false},
+		{Name: "Category", Value: cmd.Category, Inline: true},
+		{Name: "Permission", Value: cmd.Permission.String(), Inline: true},
+	}
+	if cmd.ServerTier != "" && cmd.ServerTier != serverDefault {
+		fields = append(fields, EmbedField{Name: "Server tier", Value: tierName(serverTierNames, cmd.ServerTier), Inline: true})
+	}
+	if cmd.Cooldown != "" {
+		fields = append(fields, EmbedField{Name: "Cooldown", Value: fmt.Sprintf("%ds", config.Cooldowns[cmd.Cooldown]), Inline: true})
+	}
+	if len(cmd.Aliases) > 0 {
+		fields = append(fields, EmbedField{Name: "Aliases", Value: strings.Join(cmd.Aliases, ", "), Inline: false})
+	}
+	if len(cmd.Examples) > 0 {
+		examples := ""
+		for _, example := range cmd.Examples {
+			examples += "`" + prefix + example + "`\n"
+		}
+		fields = append(fields, EmbedField{Name: "Examples", Value: examples, Inline: false})
+	}
+	return &Embed{
+		Color:  0xffff00,
+		Fields: fields,
+		Title:  cmd.Name,
+	}
+}
+
+func alts(ctx Context, args Args) error {
+	cmd, _ := lookupCommand(args.String("command"))
+	if len(cmd.Aliases) == 0 {
+		ctx.ReplyEmbed(&Embed{
+			Color: 0xff0000,
+			Fields: []EmbedField{
+				{
+					Name:   "No aliases found for '" + cmd.Name + "'",
+					Value:  "`" + cmd.Name + "` can only be used by its name",
+					Inline: true,
+				},
+			},
+			Title: "Aliases",
+		})
+		return nil
+	}
+	ctx.ReplyEmbed(&Embed{
+		Color: 0x00ff00,
+		Fields: []EmbedField{
+			{
+				Name:   "Here are all the current aliases for '" + cmd.Name + "'",
+				Value:  strings.Join(append([]string{cmd.Name}, cmd.Aliases...), ", "),
+				Inline: true,
+			},
+		},
+		Title: "Aliases",
+	})
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config holds the economy and game settings, it is loaded from a JSON file at startup.
+// Settings missing from the file keep their defaults.
+type Config struct {
+	// StartingBalance is the balance of new users.
+	StartingBalance *big.Int `json:"starting_balance"`
+	// DailyReward is the amount of money claimed by the daily command.
+	DailyReward *big.Int `json:"daily_reward"`
+	// ShareTax is the fraction of shared money that is taken as tax.
+	ShareTax float64 `json:"share_tax"`
+	// BlackjackTimeout is the number of seconds a blackjack game can go without a move before it is lost.
+	BlackjackTimeout int `json:"blackjack_timeout"`
+	// DealerThreshold is the hand total the blackjack dealer keeps drawing at or below under the classic rules.
+	DealerThreshold int `json:"dealer_threshold"`
+	// BlackjackRules is the preset of blackjack rules of servers that have not picked one (standard, h17 or classic).
+	BlackjackRules string `json:"blackjack_rules"`
+	// BlackjackMaxHands is the number of hands a blackjack player can split a pair into, 1 disables splitting.
+	BlackjackMaxHands int `json:"blackjack_max_hands"`
+	// BlackjackResplitAces allows splitting again when a split ace is dealt another ace.
+	BlackjackResplitAces bool `json:"blackjack_resplit_aces"`
+	// MaxPrefixLength is the maximum length of a server's prefix.
+	MaxPrefixLength int `json:"max_prefix_length"`
+	// DefaultPrefix is the prefix of servers that have not set one.
+	DefaultPrefix string `json:"default_prefix"`
+	// BlackjackRestart is what to do with blackjack games interrupted by a restart (resume, refund or forfeit).
+	BlackjackRestart string `json:"blackjack_restart"`
+	// RNG is the source of randomness of games, fair for provably fair games or plain for math/rand.
+	RNG string `json:"rng"`
+	// RNGSeed seeds the plain rng, so that every game can be reproduced. A seed is picked at startup if it is 0.
+	RNGSeed int64 `json:"rng_seed"`
+	// Cooldowns is the number of seconds a user has to wait between uses of a command, keyed by cooldown name.
+	// Commands without a cooldown, or with a cooldown of 0, can be used at any time.
+	Cooldowns map[string]int `json:"cooldowns"`
+	// DisabledCommands are the commands that cannot be used in a guild, keyed by guild ID.
+	DisabledCommands map[string][]string `json:"disabled_commands"`
+	// Owners are the IDs of the users who own the bot, whatever their tier is in the database.
+	Owners []string `json:"owners"`
+	// MetricsAddr is the address to serve Prometheus metrics on, such as ":9100". Metrics are not served if it is empty.
+	MetricsAddr string `json:"metrics_addr"`
+	// LogLevel is the minimum level of the lines that are logged (debug, info, warn or error).
+	LogLevel string `json:"log_level"`
+	// LogOutput is where logs are written, stderr, stdout or the path of a file to append to.
+	LogOutput string `json:"log_output"`
+}
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		StartingBalance:   big.NewInt(10000),
+		DailyReward:       big.NewInt(2000),
+		ShareTax:          0.05,
+		BlackjackTimeout:  10,
+		DealerThreshold:   15,
+		BlackjackRules:    rulesStandard,
+		BlackjackMaxHands: 4,
+		MaxPrefixLength:   2,
+		DefaultPrefix:     ",",
+		BlackjackRestart:  restartResume,
+		RNG:               rngFair,
+		LogLevel:          "info",
+		LogOutput:         "stderr",
+		Cooldowns: map[string]int{
+			"balance":    2,
+			"top":        5,
+			"blackjack":  3,
+			"fiftyfifty": 2,
+			"share":      5,
+			"history":    3,
+			"stats":      2,
+		},
+	}
+}
+
+// loadConfig reads the config at path on top of the defaults and validates it.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("could not parse config %s: %w", path, err)
+	}
+	err = cfg.validate()
+	if err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg Config) validate() error {
+	switch {
+	case cfg.StartingBalance == nil || cfg.StartingBalance.Sign() < 0:
+		return fmt.Errorf("starting_balance must not be negative")
+	case cfg.DailyReward == nil || cfg.DailyReward.Sign() < 0:
+		return fmt.Errorf("daily_reward must not be negative")
+	case cfg.ShareTax < 0 || cfg.ShareTax >= 1:
+		return fmt.Errorf("share_tax must be at least 0 and less than 1, got %v", cfg.ShareTax)
+	case cfg.BlackjackTimeout < 1:
+		return fmt.Errorf("blackjack_timeout must be at least 1 second, got %d", cfg.BlackjackTimeout)
+	case cfg.DealerThreshold < 1 || cfg.DealerThreshold > 20:
+		return fmt.Errorf("dealer_threshold must be between 1 and 20, got %d", cfg.DealerThreshold)
+	case !validBlackjackRules(cfg.BlackjackRules):
+		return fmt.Errorf("blackjack_rules must be standard, h17 or classic, got %q", cfg.BlackjackRules)
+	case cfg.BlackjackMaxHands < 1:
+		return fmt.Errorf("blackjack_max_hands must be at least 1, got %d", cfg.BlackjackMaxHands)
+	case cfg.MaxPrefixLength < 1:
+		return fmt.Errorf("max_prefix_length must be at least 1, got %d", cfg.MaxPrefixLength)
+	case cfg.DefaultPrefix == "" || strings.ContainsAny(cfg.DefaultPrefix, " \t\n"):
+		return fmt.Errorf("default_prefix must not be empty or contain whitespace")
+	case len(cfg.DefaultPrefix) > cfg.MaxPrefixLength:
+		return fmt.Errorf("default_prefix %q is longer than max_prefix_length (%d)", cfg.DefaultPrefix, cfg.MaxPrefixLength)
+	case !validRestartPolicy(cfg.BlackjackRestart):
+		return fmt.Errorf("blackjack_restart must be resume, refund or forfeit, got %q", cfg.BlackjackRestart)
+	case !validRNG(cfg.RNG):
+		return fmt.Errorf("rng must be fair or plain, got %q", cfg.RNG)
+	case !validLogLevel(cfg.LogLevel):
+		return fmt.Errorf("log_level must be debug, info, warn or error, got %q", cfg.LogLevel)
+	}
+	for guildID, disabled := range cfg.DisabledCommands {
+		for _, name := range disabled {
+			if !validCmd(name) {
+				return fmt.Errorf("unknown command %q disabled in guild %s", name, guildID)
+			}
+		}
+	}
+	for cooldown, seconds := range cfg.Cooldowns {
+		if !validCooldown(cooldown) {
+			return fmt.Errorf("unknown cooldown %q, expected one of %s", cooldown, strings.Join(cooldownNames, ", "))
+		}
+		if seconds < 0 {
+			return fmt.Errorf("cooldown %s must not be negative, got %d", cooldown, seconds)
+		}
+	}
+	return nil
+}
+
+func (cfg Config) blackjackTimeout() time.Duration {
+	return time.Duration(cfg.BlackjackTimeout) * time.Second
+}
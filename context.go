@@ -0,0 +1,92 @@
+package main
+
+// User is a user of whichever platform the bot is running on.
+type User struct {
+	ID      string
+	Name    string
+	Mention string
+}
+
+// Embed is a rich message, the platform decides how to render it.
+type Embed struct {
+	Title  string
+	Color  int
+	Fields []EmbedField
+}
+
+type EmbedField struct {
+	Name   string
+	Value  string
+	Inline bool
+}
+
+type ButtonStyle int
+
+const (
+	ButtonSuccess ButtonStyle = iota
+	ButtonDanger
+	ButtonSecondary
+)
+
+// Button is a clickable component attached to a message.
+// Clicking it invokes the component handler registered for the prefix of its ID.
+type Button struct {
+	Label    string
+	ID       string
+	Style    ButtonStyle
+	Disabled bool
+}
+
+// Response is the content of a message sent by the bot.
+type Response struct {
+	Content string
+	Embed   *Embed
+	Buttons []Button
+}
+
+// MessageRef identifies a message sent by the bot so that it can be edited later.
+type MessageRef struct {
+	ChannelID string
+	MessageID string
+}
+
+// Responder sends messages on a platform without being tied to any invocation.
+// It is used directly by background tasks such as the blackjack timeout.
+type Responder interface {
+	Send(channelID string, resp Response) (MessageRef, error)
+	Edit(ref MessageRef, resp Response) error
+	DM(userID string, resp Response) error
+	User(id string) (User, error)
+}
+
+// Context is a single invocation of a command or component by a user.
+// Handlers only talk to the platform through it.
+type Context interface {
+	Responder
+	Author() User
+	GuildID() string
+	ChannelID() string
+	// CanManageGuild reports whether the author is allowed to change the guild's settings.
+	CanManageGuild() bool
+	// Reply answers the invocation, for components the reply is only shown to the author where possible.
+	Reply(content string) (MessageRef, error)
+	ReplyEmbed(embed *Embed, buttons ...Button) (MessageRef, error)
+}
+
+// handler runs a command. Problems caused by the user are replied to by the handler itself,
+// any error it returns is logged and reported to the user as an internal error.
+type handler func(ctx Context, args Args) error
+
+// componentHandler is called when a button is clicked, with the ID of the button and the message it is attached to.
+type componentHandler func(ctx Context, buttonID string, msg MessageRef) error
+
+// component is a kind of button, the buttons whose ID starts with the prefix it is registered under.
+type component struct {
+	// Command is the command that sends the buttons. Clicks go through globalMiddleware and its guards, but not its
+	// cooldown, as playing on is part of the invocation that started the game.
+	Command string
+	Handle  componentHandler
+}
+
+// responder is used by tasks that are not part of any invocation.
+var responder Responder
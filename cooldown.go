@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrOnCooldown = errors.New("on cooldown")
+
+// cooldownNames are the columns of the cooldowns table, every command with a cooldown uses one of them.
+// The half and scratch columns were used by commands that no longer exist.
+var cooldownNames = []string{"balance", "top", "blackjack", "fiftyfifty", "share", "history", "stats"}
+
+func validCooldown(cooldown string) bool {
+	for _, c := range cooldownNames {
+		if cooldown == c {
+			return true
+		}
+	}
+	return false
+}
+
+// cooldown makes users wait between invocations of the command, for as long as config.Cooldowns sets for the named
+// cooldown. Admins are never made to wait.
+func cooldown(cooldown string) middleware {
+	if !validCooldown(cooldown) {
+		panic("unknown cooldown " + cooldown)
+	}
+	return func(name string, next handler) handler {
+		return func(ctx Context, args Args) error {
+			seconds := config.Cooldowns[cooldown]
+			if seconds <= 0 {
+				return next(ctx, args)
+			}
+			id := ctx.Author().ID
+			admin, err := isAdmin(id)
+			if err != nil {
+				return err
+			}
+			if admin {
+				return next(ctx, args)
+			}
+			available, err := store.UseCommand(id, cooldown, time.Now().Unix(), int64(seconds))
+			if err == ErrOnCooldown {
+				ctx.Reply(fmt.Sprintf("%s you can use `%s` again <t:%d:R>.", ctx.Author().Mention, name, available))
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("could not update %s cooldown of %s: %w", cooldown, id, err)
+			}
+			return next(ctx, args)
+		}
+	}
+}
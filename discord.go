@@ -0,0 +1,299 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// discordResponder is the Responder for Discord.
+type discordResponder struct {
+	s *discordgo.Session
+}
+
+func discordUser(user *discordgo.User) User {
+	return User{
+		ID:      user.ID,
+		Name:    user.Username + "#" + user.Discriminator,
+		Mention: user.Mention(),
+	}
+}
+
+func discordEmbed(embed *Embed) *discordgo.MessageEmbed {
+	if embed == nil {
+		return nil
+	}
+	fields := make([]*discordgo.MessageEmbedField, len(embed.Fields))
+	for i, field := range embed.Fields {
+		fields[i] = &discordgo.MessageEmbedField{
+			Name:   field.Name,
+			Value:  field.Value,
+			Inline: field.Inline,
+		}
+	}
+	return &discordgo.MessageEmbed{
+		Author:    &discordgo.MessageEmbedAuthor{},
+		Color:     embed.Color,
+		Fields:    fields,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Title:     embed.Title,
+	}
+}
+
+func discordComponents(buttons []Button) []discordgo.MessageComponent {
+	if len(buttons) == 0 {
+		return []discordgo.MessageComponent{}
+	}
+	components := make([]discordgo.MessageComponent, len(buttons))
+	for i, button := range buttons {
+		style := discordgo.SuccessButton
+		switch button.Style {
+		case ButtonDanger:
+			style = discordgo.DangerButton
+		case ButtonSecondary:
+			style = discordgo.SecondaryButton
+		}
+		components[i] = discordgo.Button{
+			Label:    button.Label,
+			Style:    style,
+			Disabled: button.Disabled,
+			CustomID: button.ID,
+		}
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: components,
+		},
+	}
+}
+
+func (dr discordResponder) Send(channelID string, resp Response) (MessageRef, error) {
+	send := &discordgo.MessageSend{
+		Content: resp.Content,
+		Embed:   discordEmbed(resp.Embed),
+	}
+	if len(resp.Buttons) > 0 {
+		send.Components = discordComponents(resp.Buttons)
+	}
+	msg, err := dr.s.ChannelMessageSendComplex(channelID, send)
+	if err != nil {
+		return MessageRef{}, err
+	}
+	return MessageRef{ChannelID: msg.ChannelID, MessageID: msg.ID}, nil
+}
+
+func (dr discordResponder) Edit(ref MessageRef, resp Response) error {
+	edit := discordgo.NewMessageEdit(ref.ChannelID, ref.MessageID)
+	if resp.Content != "" {
+		edit.SetContent(resp.Content)
+	}
+	if resp.Embed != nil {
+		edit.SetEmbed(discordEmbed(resp.Embed))
+	}
+	// Always set the components so that buttons are removed once they are no longer needed
+	edit.Components = discordComponents(resp.Buttons)
+	_, err := dr.s.ChannelMessageEditComplex(edit)
+	return err
+}
+
+func (dr discordResponder) DM(userID string, resp Response) error {
+	ch, err := dr.s.UserChannelCreate(userID)
+	if err != nil {
+		return err
+	}
+	_, err = dr.Send(ch.ID, resp)
+	return err
+}
+
+func (dr discordResponder) User(id string) (User, error) {
+	user, err := dr.s.User(id)
+	if err != nil {
+		return User{}, err
+	}
+	return discordUser(user), nil
+}
+
+// discordMessageContext is a command invoked by a message.
+type discordMessageContext struct {
+	discordResponder
+	m *discordgo.MessageCreate
+}
+
+func (dc discordMessageContext) Author() User {
+	return discordUser(dc.m.Author)
+}
+
+func (dc discordMessageContext) GuildID() string {
+	return dc.m.GuildID
+}
+
+func (dc discordMessageContext) ChannelID() string {
+	return dc.m.ChannelID
+}
+
+func (dc discordMessageContext) CanManageGuild() bool {
+	p, err := dc.s.State.MessagePermissions(dc.m.Message)
+	if err != nil {
+		return false
+	}
+	return p&discordgo.PermissionManageServer != 0
+}
+
+func (dc discordMessageContext) Reply(content string) (MessageRef, error) {
+	return dc.Send(dc.m.ChannelID, Response{Content: content})
+}
+
+func (dc discordMessageContext) ReplyEmbed(embed *Embed, buttons ...Button) (MessageRef, error) {
+	return dc.Send(dc.m.ChannelID, Response{Embed: embed, Buttons: buttons})
+}
+
+// discordInteractionContext is a component or command invoked by an interaction.
+// The interaction must already have been deferred.
+type discordInteractionContext struct {
+	discordResponder
+	i *discordgo.InteractionCreate
+
+	mu sync.Mutex
+	// pending is set while the deferred response of a command has not been replaced by a reply yet.
+	pending bool
+}
+
+func newDiscordInteractionContext(s *discordgo.Session, i *discordgo.InteractionCreate) *discordInteractionContext {
+	return &discordInteractionContext{
+		discordResponder: discordResponder{s},
+		i:                i,
+		pending:          i.Type == discordgo.InteractionApplicationCommand,
+	}
+}
+
+func (dc *discordInteractionContext) Author() User {
+	if dc.i.Member != nil {
+		return discordUser(dc.i.Member.User)
+	}
+	return discordUser(dc.i.User)
+}
+
+func (dc *discordInteractionContext) GuildID() string {
+	return dc.i.GuildID
+}
+
+func (dc *discordInteractionContext) ChannelID() string {
+	return dc.i.ChannelID
+}
+
+func (dc *discordInteractionContext) CanManageGuild() bool {
+	if dc.i.Member == nil {
+		return false
+	}
+	return dc.i.Member.Permissions&discordgo.PermissionManageServer != 0
+}
+
+// reply replaces the deferred response of a command, every other reply is sent as a followup.
+func (dc *discordInteractionContext) reply(params *discordgo.WebhookParams) (MessageRef, error) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	appID := dc.s.State.User.ID
+	var msg *discordgo.Message
+	var err error
+	if dc.pending {
+		msg, err = dc.s.InteractionResponseEdit(appID, dc.i.Interaction, &discordgo.WebhookEdit{
+			Content:    params.Content,
+			Components: params.Components,
+			Embeds:     params.Embeds,
+		})
+		dc.pending = err != nil
+	} else {
+		msg, err = dc.s.FollowupMessageCreate(appID, dc.i.Interaction, true, params)
+	}
+	if err != nil {
+		return MessageRef{}, err
+	}
+	return MessageRef{ChannelID: msg.ChannelID, MessageID: msg.ID}, nil
+}
+
+func (dc *discordInteractionContext) Reply(content string) (MessageRef, error) {
+	params := &discordgo.WebhookParams{
+		Content: content,
+	}
+	if dc.i.Type == discordgo.InteractionMessageComponent {
+		// Only the user who clicked needs to see it
+		params.Flags = 1 << 6 // Ephemeral
+	}
+	return dc.reply(params)
+}
+
+func (dc *discordInteractionContext) ReplyEmbed(embed *Embed, buttons ...Button) (MessageRef, error) {
+	params := &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{discordEmbed(embed)},
+	}
+	if len(buttons) > 0 {
+		params.Components = discordComponents(buttons)
+	}
+	return dc.reply(params)
+}
+
+var components = map[string]component{
+	"bj_": {Command: "blackjack", Handle: blackjackCont},
+}
+
+func ready(s *discordgo.Session, event *discordgo.Ready) {
+	s.UpdateListeningStatus("@Risk help")
+	registerSlashCommands(s)
+	blackjackSessions.Run(time.Second)
+	isReady = true
+}
+
+func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if !isReady || m.Author.Bot || m.Author.ID == s.State.User.ID {
+		return
+	}
+
+	c := m.Content
+	lc := len(c)
+	prefix, err := getPrefix(m.GuildID)
+	if err != nil {
+		rootLogger.Error("Could not get prefix", "guild_id", m.GuildID, "error", err)
+		return
+	}
+	command := strings.TrimPrefix(c, prefix)
+	command = strings.TrimPrefix(command, "<@!"+s.State.User.ID+">")
+	valid := lc > len(command)
+	args, err := tokenize(command)
+	if valid && len(args) > 0 && validCmd(args[0]) {
+		ctx := discordMessageContext{discordResponder{s}, m}
+		if err != nil {
+			ctx.Reply("Invalid syntax: " + err.Error())
+			return
+		}
+		dispatch(ctx, args[0], args[1:])
+	}
+}
+
+func interact(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		customID := i.MessageComponentData().CustomID
+		for prefix, c := range components {
+			if strings.HasPrefix(customID, prefix) {
+				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					Type: discordgo.InteractionResponseDeferredMessageUpdate,
+				})
+				ref := MessageRef{ChannelID: i.Message.ChannelID, MessageID: i.Message.ID}
+				dispatchComponent(newDiscordInteractionContext(s, i), c, customID, ref)
+				return
+			}
+		}
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		name, exists := slashCommandNames[data.Name]
+		if !exists {
+			return
+		}
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		})
+		dispatch(newDiscordInteractionContext(s, i), name, slashArgs(data))
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// In provably fair mode every game of a user is derived from a secret server seed, the user's client seed and a
+// nonce counting the games played with the server seed. The SHA-256 hash of the server seed is shown before any game
+// is played, and the seed itself is revealed once it is rotated, so that users can recompute every roll and check
+// that the seed was not swapped after they bet.
+//
+// The nth roll of a game is floor(x * n / 2^32), where x is the next 4 bytes (big endian) of
+// HMAC-SHA256(server seed, "<client seed>:<nonce>:<round>"). Each HMAC provides 8 rolls, after which the round
+// is incremented.
+
+// FairSeeds are the seeds the provably fair games of a user are derived from.
+type FairSeeds struct {
+	ServerSeed string
+	ClientSeed string
+	// Nonce is the number of games played with the server seed.
+	Nonce int64
+}
+
+// FairGame is a provably fair game, recorded so that it can be verified later.
+type FairGame struct {
+	ID         int64
+	UserID     string
+	Kind       string
+	ServerSeed string
+	ClientSeed string
+	Nonce      int64
+	// Rolls and Result are empty until the game is over.
+	Rolls  string
+	Result string
+	Time   int64
+}
+
+var ErrUnknownGame = errors.New("unknown game")
+var ErrSeedInUse = errors.New("server seed in use by a game in progress")
+
+// fairRNG derives the rolls of a provably fair game from its seeds.
+type fairRNG struct {
+	game  FairGame
+	block []byte
+	round int
+	rolls []roll
+}
+
+func newFairRNG(game FairGame) *fairRNG {
+	return &fairRNG{game: game}
+}
+
+func (r *fairRNG) Intn(n int) int {
+	if len(r.block) < 4 {
+		r.block = fairBlock(r.game.ServerSeed, r.game.ClientSeed, r.game.Nonce, r.round)
+		r.round++
+	}
+	x := binary.BigEndian.Uint32(r.block)
+	r.block = r.block[4:]
+	v := int(uint64(x) * uint64(n) >> 32)
+	r.rolls = append(r.rolls, roll{n, v})
+	return v
+}
+
+func fairBlock(serverSeed string, clientSeed string, nonce int64, round int) []byte {
+	mac := hmac.New(sha256.New, []byte(serverSeed))
+	fmt.Fprintf(mac, "%s:%d:%d", clientSeed, nonce, round)
+	return mac.Sum(nil)
+}
+
+// replayFairRNG recreates the rng of a game that already made the given rolls.
+// It fails if the seeds of the game do not produce the same rolls.
+func replayFairRNG(game FairGame, rolls []roll) (*fairRNG, error) {
+	r := newFairRNG(game)
+	err := replayRolls(r, rolls)
+	if err != nil {
+		return r, fmt.Errorf("game %d: %w", game.ID, err)
+	}
+	return r, nil
+}
+
+func hashSeed(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomSeed returns a hex encoded seed of n random bytes.
+func randomSeed(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		// crypto/rand only fails if the OS cannot provide randomness, in which case nothing should be played
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func newFairSeeds(clientSeed string) FairSeeds {
+	if clientSeed == "" {
+		clientSeed = randomSeed(8)
+	}
+	return FairSeeds{ServerSeed: randomSeed(32), ClientSeed: clientSeed}
+}
+
+// fairSource creates a provably fair rng for every game.
+type fairSource struct{}
+
+func (fairSource) gameRNG(userID string, kind string) (rng, error) {
+	_, err := store.Seeds(userID, newFairSeeds(""))
+	if err != nil {
+		return nil, fmt.Errorf("could not get seeds of %s: %w", userID, err)
+	}
+	game, err := store.StartFairGame(userID, kind)
+	if err != nil {
+		return nil, fmt.Errorf("could not start fair game of %s: %w", userID, err)
+	}
+	return newFairRNG(game), nil
+}
+
+// finishGame records the result of a game so that it can be verified or replayed.
+func finishGame(l logger, r rng, result string) {
+	switch r := r.(type) {
+	case *fairRNG:
+		err := store.FinishFairGame(r.game.ID, formatRolls(r.rolls), result)
+		if err != nil {
+			l.Error("Could not record result of game", "game_id", r.game.ID, "error", err)
+		}
+	case *seededRNG:
+		// Seeded games are not stored, their seed is logged so that they can still be replayed
+		l.Info("Finished game", "seed", r.seed, "rolls", formatRolls(r.rolls), "result", result)
+	}
+}
+
+// gameFields returns the embed fields telling the user how to verify the game, or which seed it was played with.
+func gameFields(r rng) []EmbedField {
+	switch r := r.(type) {
+	case *fairRNG:
+		return []EmbedField{
+			{
+				Name:   "Provably fair",
+				Value:  fmt.Sprintf("Game #%d, check it with `verify %d`", r.game.ID, r.game.ID),
+				Inline: false,
+			},
+		}
+	case *seededRNG:
+		return []EmbedField{
+			{
+				Name:   "Seed",
+				Value:  strconv.FormatInt(r.seed, 10),
+				Inline: false,
+			},
+		}
+	}
+	return nil
+}
+
+// gameInProgress reports whether the user has a game in progress, whose server seed must not be revealed until it is over.
+// Games that are not in a session are caught by RotateSeeds, which refuses to replace a seed of an unfinished game.
+func gameInProgress(userID string) bool {
+	return blackjackSessions.Has(sessionKey{UserID: userID})
+}
+
+func seed(ctx Context, args Args) error {
+	id := ctx.Author().ID
+	seeds, err := store.Seeds(id, newFairSeeds(""))
+	if err != nil {
+		return fmt.Errorf("could not get seeds of %s: %w", id, err)
+	}
+	if !args.Has("client seed") {
+		ctx.ReplyEmbed(&Embed{
+			Color: 0xffff00,
+			Fields: []EmbedField{
+				{
+					Name:   "Server seed hash",
+					Value:  "`" + hashSeed(seeds.ServerSeed) + "`",
+					Inline: false,
+				},
+				{
+					Name:   "Client seed",
+					Value:  "`" + seeds.ClientSeed + "`",
+					Inline: true,
+				},
+				{
+					Name:   "Nonce",
+					Value:  strconv.FormatInt(seeds.Nonce, 10),
+					Inline: true,
+				},
+				{
+					Name:   "Changing seeds",
+					Value:  "Use `seed <client seed>` to set your client seed, this also reveals your current server seed and replaces it.",
+					Inline: false,
+				},
+			},
+			Title: "Seeds",
+		})
+		return nil
+	}
+
+	clientSeed := args.String("client seed")
+	if len(clientSeed) > 64 {
+		ctx.Reply("Client seeds should be no longer than 64 characters.")
+		return nil
+	}
+	next := newFairSeeds(clientSeed)
+	if gameInProgress(id) {
+		ctx.Reply("You cannot change your seeds while a game is in progress, finish it first.")
+		return nil
+	}
+	err = store.RotateSeeds(id, next)
+	if err == ErrSeedInUse {
+		ctx.Reply("You cannot change your seeds while a game is in progress, finish it first.")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not rotate seeds of %s: %w", id, err)
+	}
+	ctx.ReplyEmbed(&Embed{
+		Color: 0x00ff00,
+		Fields: []EmbedField{
+			{
+				Name:   "Previous server seed",
+				Value:  "`" + seeds.ServerSeed + "`\nUsed for " + strconv.FormatInt(seeds.Nonce, 10) + " games with client seed `" + seeds.ClientSeed + "`",
+				Inline: false,
+			},
+			{
+				Name:   "New server seed hash",
+				Value:  "`" + hashSeed(next.ServerSeed) + "`",
+				Inline: false,
+			},
+			{
+				Name:   "New client seed",
+				Value:  "`" + next.ClientSeed + "`",
+				Inline: false,
+			},
+		},
+		Title: "Seeds changed",
+	})
+	return nil
+}
+
+func verify(ctx Context, args Args) error {
+	arg := args.String("game id")
+	gameID, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil {
+		ctx.Reply("Invalid game ID: " + arg)
+		return nil
+	}
+	game, err := store.FairGame(gameID)
+	if err == ErrUnknownGame {
+		ctx.Reply(fmt.Sprintf("There is no game #%d.", gameID))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get game %d: %w", gameID, err)
+	}
+	if game.Result == "" {
+		ctx.Reply(fmt.Sprintf("Game #%d is still in progress.", gameID))
+		return nil
+	}
+
+	seeds, err := store.Seeds(game.UserID, newFairSeeds(""))
+	if err != nil {
+		return fmt.Errorf("could not get seeds of %s: %w", game.UserID, err)
+	}
+	rotated := false
+	if seeds.ServerSeed == game.ServerSeed {
+		// Revealing a server seed that is still in use would let its future games be predicted
+		if game.UserID != ctx.Author().ID {
+			ctx.Reply(fmt.Sprintf("The server seed of game #%d has not been revealed yet, only the player can reveal it.", gameID))
+			return nil
+		}
+		if gameInProgress(game.UserID) {
+			ctx.Reply(fmt.Sprintf("The server seed of game #%d is still used by a game in progress, verify it once that game is over.", gameID))
+			return nil
+		}
+		err = store.RotateSeeds(game.UserID, newFairSeeds(seeds.ClientSeed))
+		if err == ErrSeedInUse {
+			ctx.Reply(fmt.Sprintf("The server seed of game #%d is still used by a game in progress, verify it once that game is over.", gameID))
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not rotate seeds of %s: %w", game.UserID, err)
+		}
+		rotated = true
+	}
+
+	verdict := "The rolls match the seeds."
+	color := 0x00ff00
+	rolls, err := parseRolls(game.Rolls)
+	if err == nil {
+		_, err = replayFairRNG(game, rolls)
+	}
+	if err != nil {
+		verdict = "The rolls do not match the seeds: " + err.Error()
+		color = 0xff0000
+	}
+
+	player := game.UserID
+	if user, err := ctx.User(game.UserID); err == nil {
+		player = user.Mention
+	}
+	fields := []EmbedField{
+		{
+			Name:   "Game",
+			Value:  fmt.Sprintf("%s by %s <t:%d:R>\nResult: %s", game.Kind, player, game.Time, game.Result),
+			Inline: false,
+		},
+		{
+			Name:   "Server seed",
+			Value:  "`" + game.ServerSeed + "`\nSHA-256: `" + hashSeed(game.ServerSeed) + "`",
+			Inline: false,
+		},
+		{
+			Name:   "Client seed",
+			Value:  "`" + game.ClientSeed + "`",
+			Inline: true,
+		},
+		{
+			Name:   "Nonce",
+			Value:  strconv.FormatInt(game.Nonce, 10),
+			Inline: true,
+		},
+		{
+			Name:   "Rolls (n:roll)",
+			Value:  "`" + game.Rolls + "`\nEach roll is floor(x * n / 2^32), where x is the next 4 bytes of HMAC-SHA256(server seed, \"client seed:nonce:round\"), starting at round 0.",
+			Inline: false,
+		},
+		{
+			Name:   "Verdict",
+			Value:  verdict,
+			Inline: false,
+		},
+	}
+	if rotated {
+		fields = append(fields, EmbedField{
+			Name:   "Seeds changed",
+			Value:  "The server seed was still in use, so it has been replaced. Use `seed` to see the new hash.",
+			Inline: false,
+		})
+	}
+	ctx.ReplyEmbed(&Embed{
+		Color:  color,
+		Fields: fields,
+		Title:  fmt.Sprintf("Verify game #%d", gameID),
+	})
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Reasons recorded in the ledger for every balance change.
+const (
+	reasonDaily                 = "daily"
+	reasonShare                 = "share"
+	reasonTax                   = "share tax"
+	reasonFiftyFiftyBet         = "50/50 bet"
+	reasonFiftyFiftyWin         = "50/50 win"
+	reasonFiftyFiftyLoss        = "50/50 loss"
+	reasonBlackjackBet          = "blackjack bet"
+	reasonBlackjackDouble       = "blackjack double down"
+	reasonBlackjackSplit        = "blackjack split"
+	reasonBlackjackInsurance    = "blackjack insurance"
+	reasonBlackjackInsuranceWin = "blackjack insurance win"
+	reasonBlackjackEvenMoney    = "blackjack even money"
+	reasonBlackjackNatural      = "blackjack natural"
+	reasonBlackjackWin          = "blackjack win"
+	reasonBlackjackPush         = "blackjack push"
+	reasonBlackjackLoss         = "blackjack loss"
+	reasonBlackjackForfeit      = "blackjack forfeit"
+	reasonBlackjackTimeout      = "blackjack timeout"
+	reasonBlackjackRefund       = "blackjack refund"
+	reasonAdminSet              = "admin set"
+	reasonAdminAdd              = "admin adjustment"
+	reasonAdminReset            = "admin reset"
+)
+
+// LedgerEntry is a single balance change of a user, entries are never modified once written.
+type LedgerEntry struct {
+	UserID       string
+	Amount       *big.Int
+	Balance      *big.Int
+	Reason       string
+	Counterparty string
+	Time         int64
+}
+
+const historyPageSize = 10
+
+func history(ctx Context, args Args) error {
+	id := ctx.Author().ID
+	if args.Has("user") {
+		id = args.UserID("user")
+	}
+	user, err := createUser(ctx, id)
+	if err != nil {
+		ctx.Reply("Could not find user.")
+		return nil
+	}
+
+	count, err := store.HistoryCount(id)
+	if err != nil {
+		return fmt.Errorf("could not count ledger entries of %s: %w", id, err)
+	}
+	pages := int(math.Ceil(float64(count) / historyPageSize))
+	if pages == 0 {
+		ctx.Reply(user.Name + " has no transactions yet.")
+		return nil
+	}
+
+	page := args.Page("page")
+	if page > pages {
+		ctx.Reply(fmt.Sprintf("Exceeded number of pages: %d/%d", page, pages))
+		return nil
+	}
+
+	entries, err := store.History(id, (page-1)*historyPageSize, historyPageSize)
+	if err != nil {
+		return fmt.Errorf("could not get ledger entries of %s: %w", id, err)
+	}
+
+	message := ""
+	for _, entry := range entries {
+		sign := ""
+		if entry.Amount.Sign() >= 0 {
+			sign = "+"
+		}
+		message += fmt.Sprintf("<t:%d:R> `%s$%s` %s", entry.Time, sign, entry.Amount, entry.Reason)
+		if entry.Counterparty != "" {
+			counterparty := entry.Counterparty
+			if cp, err := ctx.User(entry.Counterparty); err == nil {
+				counterparty = cp.Mention
+			}
+			message += " (" + counterparty + ")"
+		}
+		message += " ➤ $" + entry.Balance.String() + "\n"
+	}
+
+	ctx.ReplyEmbed(&Embed{
+		Color: 0xffff00,
+		Fields: []EmbedField{
+			{
+				Name:   fmt.Sprintf("Transactions of %s (page %d/%d)", user.Name, page, pages),
+				Value:  message,
+				Inline: true,
+			},
+		},
+		Title: "History",
+	})
+	return nil
+}
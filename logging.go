@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+func validLogLevel(name string) bool {
+	_, ok := parseLogLevel(name)
+	return ok
+}
+
+func parseLogLevel(name string) (logLevel, bool) {
+	for i, n := range logLevelNames {
+		if name == n {
+			return logLevel(i), true
+		}
+	}
+	return 0, false
+}
+
+// logSink is where log lines are written, it is shared by every logger.
+type logSink struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+}
+
+// logger writes log lines as JSON objects, one per line, along with the fields it was created with.
+type logger struct {
+	sink *logSink
+	// fields alternate between keys and values.
+	fields []interface{}
+}
+
+// rootLogger is the logger of everything that is not part of an invocation, see logFor for those that are.
+var rootLogger = logger{sink: &logSink{w: os.Stderr, level: levelInfo}}
+
+// setupLogging sets the minimum level of the lines that are logged and where they are written, output is stderr,
+// stdout or the path of a file to append to. Lines written through the log package, such as discordgo's, are logged
+// as warnings since libraries only log problems.
+func setupLogging(level string, output string) error {
+	lvl, ok := parseLogLevel(level)
+	if !ok {
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+	}
+	var w io.Writer
+	switch output {
+	case "", "stderr":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open log file: %w", err)
+		}
+		w = f
+	}
+	rootLogger.sink.mu.Lock()
+	rootLogger.sink.w = w
+	rootLogger.sink.level = lvl
+	rootLogger.sink.mu.Unlock()
+
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+	return nil
+}
+
+// stdLogWriter logs the lines written to it by the log package.
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	rootLogger.Warn(strings.TrimSpace(string(p)), "source", "log")
+	return len(p), nil
+}
+
+// With returns a logger that adds the key-value pairs to every line.
+func (l logger) With(kv ...interface{}) logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	return logger{l.sink, append(append(fields, l.fields...), kv...)}
+}
+
+func (l logger) Debug(msg string, kv ...interface{}) {
+	l.log(levelDebug, msg, kv)
+}
+
+func (l logger) Info(msg string, kv ...interface{}) {
+	l.log(levelInfo, msg, kv)
+}
+
+func (l logger) Warn(msg string, kv ...interface{}) {
+	l.log(levelWarn, msg, kv)
+}
+
+func (l logger) Error(msg string, kv ...interface{}) {
+	l.log(levelError, msg, kv)
+}
+
+// Fatal logs the line as an error and exits.
+func (l logger) Fatal(msg string, kv ...interface{}) {
+	l.log(levelError, msg, kv)
+	os.Exit(1)
+}
+
+func (l logger) log(level logLevel, msg string, kv []interface{}) {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	if level < l.sink.level {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeLogValue(&b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeLogValue(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeLogValue(&b, msg)
+	fields := append(append(make([]interface{}, 0, len(l.fields)+len(kv)), l.fields...), kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields[:len(fields)-1], "!BADKEY", fields[len(fields)-1])
+	}
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteByte(',')
+		writeLogValue(&b, fmt.Sprint(fields[i]))
+		b.WriteByte(':')
+		writeLogValue(&b, fields[i+1])
+	}
+	b.WriteString("}\n")
+	l.sink.w.Write(b.Bytes())
+}
+
+// writeLogValue writes the value as JSON. Errors and values with a String method are written as strings, anything
+// that cannot be marshalled is formatted with fmt.
+func writeLogValue(b *bytes.Buffer, v interface{}) {
+	switch s := v.(type) {
+	case error:
+		v = s.Error()
+	case fmt.Stringer:
+		v = s.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// requestContext is an invocation along with the logger of its lines.
+type requestContext struct {
+	Context
+	log logger
+}
+
+// withRequest gives the invocation of the command or component named name a logger, every line of which carries the
+// guild, channel, user, command and an ID unique to the invocation.
+func withRequest(ctx Context, name string) requestContext {
+	return requestContext{ctx, rootLogger.With(
+		"guild_id", ctx.GuildID(),
+		"channel_id", ctx.ChannelID(),
+		"user_id", ctx.Author().ID,
+		"command", name,
+		"request_id", newRequestID(),
+	)}
+}
+
+// logFor returns the logger of the invocation.
+func logFor(ctx Context) logger {
+	if rc, ok := ctx.(requestContext); ok {
+		return rc.log
+	}
+	return rootLogger.With("guild_id", ctx.GuildID(), "channel_id", ctx.ChannelID(), "user_id", ctx.Author().ID)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
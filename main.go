@@ -1,14 +1,10 @@
 package main
 
 import (
-	"database/sql"
-	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"math"
 	"math/big"
-	"math/rand"
 	"os"
 	"os/signal"
 	"strconv"
@@ -17,84 +13,107 @@ import (
 	"time"
 
 	"github.com/bwmarrin/discordgo"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 func init() {
 	flag.StringVar(&token, "t", "", "Bot Token")
-	flag.Parse()
+	flag.StringVar(&configPath, "c", "", "Path to a JSON config file, the defaults are used if none is given")
+	flag.StringVar(&storeType, "store", "sqlite", "Storage backend (sqlite or memory)")
+	flag.StringVar(&restartPolicy, "bj-restart", "", "What to do with blackjack games interrupted by a restart (resume, refund or forfeit), overrides the config")
+	flag.BoolVar(&listMigrations, "migrations", false, "List the database migrations and whether they have been applied, then exit")
+	flag.StringVar(&logLevelFlag, "log-level", "", "Minimum level of the lines that are logged (debug, info, warn or error), overrides the config")
+	flag.StringVar(&logOutputFlag, "log-output", "", "Where logs are written (stderr, stdout or a file path), overrides the config")
+	flag.IntVar(&simulateGames, "simulate", 0, "Play this many blackjack games in memory with a seeded rng and print the results, then exit")
 }
 
 var token string
+var configPath string
+var storeType string
+var restartPolicy string
+var logLevelFlag string
+var logOutputFlag string
+var listMigrations bool
+var simulateGames int
 var isReady = false
 
-var db *sql.DB
+var store Store
 
-var commands map[string]func(*discordgo.Session, *discordgo.MessageCreate, []string) = map[string]func(*discordgo.Session, *discordgo.MessageCreate, []string){
-	"commands":     help,
-	"help":         help,
-	"h":            help,
-	"cmds":         help,
-	"cmd":          help,
-	"daily":        daily,
-	"d":            daily,
-	"prefix":       prefix,
-	"aliases":      alts,
-	"alternatives": alts,
-	"alts":         alts,
-	"alt":          alts,
-	"balance":      balance,
-	"bal":          balance,
-	"money":        balance,
-	"top":          top,
-	"leaderboard":  top,
-	"lb":           top,
-	"stats":        stats,
-	"share":        share,
-	"give":         share,
-	"gift":         share,
-	"blackjack":    blackjack,
-	"bj":           blackjack,
-	"50/50":        fiftyfifty,
-	"fiftyfifty":   fiftyfifty,
-	"5050":         fiftyfifty,
-}
-var cmdDescs = map[string]string{
-	"commands":              "Displays a list of commands.",
-	"prefix [prefix]":       "Shows or sets the current prefix.",
-	"aliases [command]":     "Shows all aliases for the command.",
-	"balance [user]":        "Displays the amount of money the user has.",
-	"daily":                 "Claim your daily supply of money.",
-	"top [page]":            "Shows the top players.",
-	"stats [user]":          "Shows the user's stats.",
-	"share <amount> <user>": "Shares coins with the user.",
-	"blackjack <bet>":       "Play a game of blackjack.",
-	"50/50 [bet]":           "50% chance of winning, how lucky are you?",
-}
-var aliases = [][]string{
-	{"commands", "help", "h", "cmds", "cmd"},
-	{"prefix"},
-	{"aliases", "alternatives", "alt", "alts"},
-	{"balance", "bal", "money"},
-	{"daily", "d"},
-	{"top", "leaderboard", "lb"},
-	{"stats"},
-	{"share", "give", "gift"},
-	{"blackjack", "bj"},
-	{"50/50", "fiftyfifty", "5050"},
-}
+const sqlitePath = "./sqlite.db"
 
 func main() {
-	if token == "" {
-		fmt.Println("No token provided. Please run: risk -t <bot token>")
+	// Flags are parsed here rather than in init so that tests can define their own
+	flag.Parse()
+	if listMigrations {
+		db, err := openSQLite(sqlitePath)
+		if err != nil {
+			rootLogger.Fatal("Could not open database", "error", err)
+		}
+		defer db.Close()
+		err = printMigrations(db)
+		if err != nil {
+			rootLogger.Fatal("Could not list migrations", "error", err)
+		}
 		return
 	}
 
+	if configPath != "" {
+		cfg, err := loadConfig(configPath)
+		if err != nil {
+			rootLogger.Fatal("Could not load config", "error", err)
+		}
+		config = cfg
+	}
+	if restartPolicy != "" {
+		if !validRestartPolicy(restartPolicy) {
+			rootLogger.Fatal("Invalid blackjack restart policy, expected resume, refund or forfeit", "policy", restartPolicy)
+		}
+		config.BlackjackRestart = restartPolicy
+	}
+	if logLevelFlag != "" {
+		config.LogLevel = logLevelFlag
+	}
+	if logOutputFlag != "" {
+		config.LogOutput = logOutputFlag
+	}
+	err := setupLogging(config.LogLevel, config.LogOutput)
+	if err != nil {
+		rootLogger.Fatal("Could not set up logging", "error", err)
+	}
+	blackjackSessions = newSessionManager(config.blackjackTimeout(), blackjackTimeout)
+	if config.RNG == rngPlain || simulateGames > 0 {
+		seed := config.RNGSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		rootLogger.Info("Seeding games", "seed", seed)
+		gameRNGs = newSeededSource(seed)
+	}
+
+	if simulateGames > 0 {
+		simulateBlackjack(simulateGames)
+		return
+	}
+
+	if token == "" {
+		rootLogger.Fatal("No token provided. Please run: risk -t <bot token>")
+	}
+
+	err = initStore()
+	if err != nil {
+		rootLogger.Fatal("Could not open store", "error", err)
+	}
+	if config.MetricsAddr != "" {
+		go serveMetrics(config.MetricsAddr)
+	}
+
 	dg, err := discordgo.New("Bot " + token)
 	if err != nil {
-		log.Fatalln("Error creating Discord session:", err)
+		rootLogger.Fatal("Could not create Discord session", "error", err)
 	}
 
+	responder = discordResponder{dg}
+	restoreBlackjackGames()
+
 	dg.AddHandler(messageCreate)
 	dg.AddHandler(ready)
 	dg.AddHandler(interact)
@@ -103,554 +122,343 @@ func main() {
 
 	err = dg.Open()
 	if err != nil {
-		log.Fatalln("Error opening connection:", err)
+		rootLogger.Fatal("Could not open connection", "error", err)
 	}
 
-	err = initDB()
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	rand.Seed(time.Now().UnixNano())
-	fmt.Println("Bot is now running.  Press CTRL-C to exit.")
+	rootLogger.Info("Bot is now running, press CTRL-C to exit")
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
 	dg.Close()
-	db.Close()
-}
-
-func initDB() error {
-	isNew := false
-
-	if _, err := os.Stat("sqlite.db"); err == nil {
-		fmt.Println("Existing sqlite.db found, proceeding")
-	} else {
-		fmt.Println("No existing sqlite.db found, creating...")
-		file, err := os.Create("sqlite.db")
-		file.Close()
-		if err != nil {
-			return errors.New("Could not create sqlite.db: " + err.Error())
-		}
-		isNew = true
-		fmt.Println("Created new sqlite.db")
-		fmt.Println("Initializing sqlite.db with SQLite3")
-	}
-
-	db, _ = sql.Open("sqlite3", "./sqlite.db")
-
-	if isNew {
-		err := initTables(db)
-		if err != nil {
-			log.Fatalln("Could not initialize tables:", err.Error())
-		}
-	}
-
-	return nil
+	store.Close()
 }
 
-func initTables(db *sql.DB) error {
-	tableNames := []string{"servers", "users", "cooldowns"}
-	statements := []string{
-		"CREATE TABLE IF NOT EXISTS `servers` (`id` TEXT NOT NULL PRIMARY KEY, `type` TEXT NOT NULL DEFAULT 'DEFAULT', `prefix` TEXT NOT NULL DEFAULT ',');",
-		"CREATE TABLE IF NOT EXISTS `users` (`id` TEXT NOT NULL PRIMARY KEY, `type` TEXT NOT NULL DEFAULT 'DEFAULT', `balance` TEXT NOT NULL DEFAULT '0', `games` INTEGER NOT NULL DEFAULT 0, `daily` INTEGER NOT NULL DEFAULT 0, `ff_wins` INTEGER NOT NULL DEFAULT 0, `ff_losses` INTEGER NOT NULL DEFAULT 0, `bj_wins` INTEGER NOT NULL DEFAULT 0, `bj_losses` INTEGER NOT NULL DEFAULT 0);",
-		"CREATE TABLE IF NOT EXISTS `cooldowns` (`user_id` TEXT NOT NULL PRIMARY KEY, `balance` INTEGER NOT NULL DEFAULT 0, `top` INTEGER NOT NULL DEFAULT 0, `blackjack` INTEGER NOT NULL DEFAULT 0, `half` INTEGER NOT NULL DEFAULT 0, `scratch` INTEGER NOT NULL DEFAULT 0);"}
-
-	tableName := ""
-	for i := 0; i < len(tableNames); i++ {
-		tableName = tableNames[i]
-		fmt.Println("Creating,", tableName, "table...")
-		statement, err := db.Prepare(statements[i])
+func initStore() error {
+	switch storeType {
+	case "sqlite":
+		ss, err := newSQLiteStore(sqlitePath, config.DefaultPrefix)
 		if err != nil {
-			return errors.New("Could not create " + tableName + " table: " + err.Error())
+			return err
 		}
-		_, err = statement.Exec()
-		if err != nil {
-			return errors.New("Could not create " + tableName + " table: " + err.Error())
-		}
-		defer statement.Close()
-		fmt.Println("Created", tableName, "table successfully.")
-	}
-
+		store = ss
+	case "memory":
+		rootLogger.Warn("Using in-memory storage, nothing will be saved")
+		store = newMemoryStore(config.DefaultPrefix)
+	default:
+		return fmt.Errorf("unknown storage backend %q, expected sqlite or memory", storeType)
+	}
+	store = meteredStore{store}
 	return nil
 }
 
-func ready(s *discordgo.Session, event *discordgo.Ready) {
-	s.UpdateListeningStatus("@Risk help")
-	isReady = true
-}
-
-func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if !isReady || m.Author.Bot || m.Author.ID == s.State.User.ID {
-		return
-	}
-	if !autoInvalidatorRunning {
-		autoInvalidatorRunning = true
-		go autoInvalidator(s)
-	}
-
-	c := m.Content
-	lc := len(c)
-	prefix := getPrefix(m.GuildID)
-	command := strings.TrimPrefix(c, prefix)
-	command = strings.TrimPrefix(command, "<@!"+s.State.User.ID+">")
-	valid := lc > len(command)
-	command = strings.TrimSpace(command)
-	args := strings.Split(command, " ")
-	if valid && len(args) > 0 && validCmd(args[0]) {
-		commands[args[0]](s, m, args[1:])
+func getPrefix(id string) (string, error) {
+	prefix, err := store.Prefix(id)
+	if err != nil {
+		return "", fmt.Errorf("could not get prefix of server %s: %w", id, err)
 	}
+	return prefix, nil
 }
 
-func validCmd(name string) bool {
-	for c := range commands {
-		if name == c {
-			return true
+func prefix(ctx Context, args Args) error {
+	if !args.Has("prefix") {
+		p, err := getPrefix(ctx.GuildID())
+		if err != nil {
+			return err
 		}
+		ctx.Reply("The current prefix is " + p)
+		return nil
 	}
-	return false
-}
-
-func getPrefix(id string) string {
-	row, err := db.Query("SELECT prefix FROM servers WHERE id=?", id)
-	if err != nil {
-		log.Fatalln("Could not get prefix from server:", err)
-	}
-	defer row.Close()
-	if row.Next() {
-		var prefix string
-		row.Scan(&prefix)
-		return prefix
+	p := args.String("prefix")
+	if len(p) > config.MaxPrefixLength {
+		ctx.Reply(fmt.Sprintf("Prefix should be no longer than %d characters! This is done to save space.", config.MaxPrefixLength))
+		return nil
 	}
-	insertServerSQL := "INSERT INTO servers (id, prefix) VALUES (?, ',')"
-	statement, err := db.Prepare(insertServerSQL)
+	err := setPrefix(ctx.GuildID(), p)
 	if err != nil {
-		log.Fatalln("Could not initialize server into db:", err)
+		return err
 	}
-	statement.Exec(id)
-	defer statement.Close()
-	return ","
+	ctx.Reply("Prefix has been successfully changed to '" + p + "'")
+	return nil
 }
 
-func hasPerms(s *discordgo.Session, message *discordgo.Message, perms int64) bool {
-	p, err := s.State.MessagePermissions(message)
+func setPrefix(id string, prefix string) error {
+	err := store.SetPrefix(id, prefix)
 	if err != nil {
-		log.Fatalln("Could not get permissions from message:", err)
-	}
-	return p&perms != 0
-}
-
-func prefix(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	if !hasPerms(s, m.Message, discordgo.PermissionManageServer) {
-		s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" you do not have the necessary permissions to change the prefix (Manage Server).")
-		return
-	}
-	if len(args) == 0 {
-		s.ChannelMessageSend(m.ChannelID, "The current prefix is "+getPrefix(m.GuildID))
-		return
+		return fmt.Errorf("could not change prefix of server %s to %s: %w", id, prefix, err)
 	}
-	p := args[0]
-	if len(p) > 2 {
-		s.ChannelMessageSend(m.ChannelID, "Prefix should be no longer than 2 characters! This is done to save space.")
-		return
-	}
-	setPrefix(m.GuildID, p)
-	s.ChannelMessageSend(m.ChannelID, "Prefix has been successfully changed to '"+p+"'")
+	return nil
 }
 
-func setPrefix(id string, prefix string) {
-	stmt, err := db.Prepare("UPDATE servers SET prefix=? WHERE id=?")
-	if err != nil {
-		log.Fatalln("Could not change prefix of server", id, "to", prefix, ":", err)
-	}
-	_, err = stmt.Exec(prefix, id)
+func fiftyfifty(ctx Context, args Args) error {
+	_, err := createUser(ctx, ctx.Author().ID)
 	if err != nil {
-		log.Fatalln("Could not change prefix of server", id, "to", prefix, ":", err)
+		return err
 	}
-	defer stmt.Close()
-}
-
-func fiftyfifty(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	rand.Seed(time.Now().UnixNano())
 	color := 0x00ff00
-	message := m.Author.Mention() + " won their 50/50! :)"
+	message := ctx.Author().Mention + " won their 50/50! :)"
 	bet := big.NewInt(0)
-	balance := getBalance(m.Author.ID)
 	isBetting := false
-	if len(args) != 0 {
-		bet = getBet(m.Author.ID, args[0])
+	if args.Has("bet") {
+		var ok bool
+		bet, ok, err = getBet(ctx, args.Amount("bet"))
+		if !ok {
+			return err
+		}
 		isBetting = bet.Cmp(big.NewInt(0)) == 1
 	}
-	if rand.Intn(2) == 0 {
-		message = m.Author.Mention() + " lost their 50/50 :("
+	r, err := newGameRNG(ctx.Author().ID, "50/50")
+	if err != nil {
+		return err
+	}
+	won := r.Intn(2) != 0
+	// The stake is taken along with the payout, so that a bet the balance no longer covers can neither win nor lose
+	payout := new(big.Int).Lsh(bet, 1)
+	reason := reasonFiftyFiftyWin
+	result := "won"
+	if !won {
+		message = ctx.Author().Mention + " lost their 50/50 :("
 		color = 0xff0000
-		bet.Sub(big.NewInt(0), bet)
-		addStat(m.Author.ID, "ff_losses", 1)
-	} else {
-		addStat(m.Author.ID, "ff_wins", 1)
+		payout = big.NewInt(0)
+		reason = reasonFiftyFiftyLoss
+		result = "lost"
 	}
+	finishGame(logFor(ctx), r, result)
 
-	newBalance := balance.Add(balance, bet)
-	setBalance(m.Author.ID, newBalance)
 	if isBetting {
-		message += "\nTheir balance is now " + newBalance.String()
+		balances, err := store.Transact(
+			BalanceChange{ID: ctx.Author().ID, Amount: new(big.Int).Neg(bet), Reason: reasonFiftyFiftyBet},
+			BalanceChange{ID: ctx.Author().ID, Amount: payout, Reason: reason},
+		)
+		if err == ErrInsufficientFunds {
+			ctx.Reply(ctx.Author().Mention + " you no longer have enough money for that bet.")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not update balance of %s: %w", ctx.Author().ID, err)
+		}
+		message += "\nTheir balance is now " + balances[1].String()
+	}
+	if won {
+		addStat(logFor(ctx), ctx.Author().ID, "ff_wins", 1)
+	} else {
+		addStat(logFor(ctx), ctx.Author().ID, "ff_losses", 1)
 	}
 
-	embed := &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{},
-		Color:  color,
-		Fields: []*discordgo.MessageEmbedField{
+	embed := &Embed{
+		Color: color,
+		Fields: append([]EmbedField{
 			{
 				Name:   "Results",
 				Value:  message,
 				Inline: true,
 			},
-		},
-		Timestamp: time.Now().Format(time.RFC3339),
-		Title:     "50/50",
+		}, gameFields(r)...),
+		Title: "50/50",
 	}
-	s.ChannelMessageSendEmbed(m.ChannelID, embed)
-}
-
-func help(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	message := ""
-	for cmd, desc := range cmdDescs {
-		message += cmd + ": `" + desc + "`\n"
-	}
-	s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{},
-		Color:  0xffff00,
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:   "Here are all the current commands!",
-				Value:  message,
-				Inline: true,
-			},
-		},
-		Timestamp: time.Now().Format(time.RFC3339),
-		Title:     "Commands",
-	})
+	ctx.ReplyEmbed(embed)
+	return nil
 }
 
-func alts(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	if len(args) == 0 {
-		s.ChannelMessageSend(m.ChannelID, "You need to specify a command to look for aliases for.")
-		return
-	}
-	q := args[0]
-	for _, alts := range aliases {
-		contains := false
-		message := ""
-		for _, alt := range alts {
-			message += alt + ", "
-			if q == alt {
-				contains = true
-			}
-		}
-		if contains {
-			s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
-				Author: &discordgo.MessageEmbedAuthor{},
-				Color:  0x00ff00,
-				Fields: []*discordgo.MessageEmbedField{
-					{
-						Name:   "Here are all the current aliases for '" + q + "'",
-						Value:  strings.TrimSuffix(message, ", "),
-						Inline: true,
-					},
-				},
-				Timestamp: time.Now().Format(time.RFC3339),
-				Title:     "Aliases",
-			})
-			return
-		}
+func balance(ctx Context, args Args) error {
+	id := ctx.Author().ID
+	if args.Has("user") {
+		id = args.UserID("user")
 	}
-	s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{},
-		Color:  0xff0000,
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:   "No aliases found for '" + q + "'",
-				Value:  "Try looking up aliases for a command listed in 'commands'",
-				Inline: true,
-			},
-		},
-		Timestamp: time.Now().Format(time.RFC3339),
-		Title:     "Aliases",
-	})
-}
 
-func balance(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	if len(args) == 0 {
-		s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" has $"+getBalance(m.Author.ID).String())
-		return
-	}
-	id, err := getID(args[0])
+	user, err := createUser(ctx, id)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" "+args[0]+" is not a valid User ID.\nPlease ping the user or copy their ID and paste it.")
-		return
+		ctx.Reply(ctx.Author().Mention + " " + id + " is not a valid User ID.\nPlease ping the user or copy their ID and paste it.")
+		return nil
 	}
-
-	user, err := createUser(s, id)
+	bal, err := getBalance(user.ID)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" "+args[0]+" is not a valid User ID.\nPlease ping the user or copy their ID and paste it.")
-		return
+		return err
 	}
-	createUser(s, id)
-	s.ChannelMessageSend(m.ChannelID, user.Username+"#"+user.Discriminator+" has $"+getBalance(user.ID).String())
+	if !args.Has("user") {
+		ctx.Reply(ctx.Author().Mention + " has $" + bal.String())
+	} else {
+		ctx.Reply(user.Name + " has $" + bal.String())
+	}
+	return nil
 }
 
-func getBalance(id string) *big.Int {
-	stmt, err := db.Prepare("SELECT balance FROM users WHERE id=?")
+func getBalance(id string) (*big.Int, error) {
+	balance, err := store.Balance(id)
 	if err != nil {
-		log.Fatalln("Could not get user's balance:", err)
+		return nil, fmt.Errorf("could not get balance of %s: %w", id, err)
 	}
-	var balanceStr string
-	err = stmt.QueryRow(id).Scan(&balanceStr)
-	if err != nil {
-		log.Fatalln("Could not get user's balance:", err)
-	}
-	balance, suc := new(big.Int).SetString(balanceStr, 10)
-	if !suc {
-		log.Fatalln("Could not interpret user's balance:", balanceStr)
-	}
-	return balance
+	return balance, nil
 }
 
-func setBalance(id string, bal *big.Int) {
-	stmt, err := db.Prepare("UPDATE users SET balance=? WHERE id=?")
-	if err != nil {
-		log.Fatalln("Could not update user's balance:", err)
-	}
-	_, err = stmt.Exec(bal.String(), id)
+// addBalance credits the user and returns their new balance.
+// Debits should call store.Transact directly so that they can handle ErrInsufficientFunds.
+func addBalance(id string, change *big.Int, reason string) (*big.Int, error) {
+	balances, err := store.Transact(BalanceChange{ID: id, Amount: change, Reason: reason})
 	if err != nil {
-		log.Fatalln("Could not update user's balance:", err)
+		return nil, fmt.Errorf("could not update balance of %s: %w", id, err)
 	}
-	defer stmt.Close()
+	return balances[0], nil
 }
 
-func addBalance(id string, change *big.Int) *big.Int {
-	bal := getBalance(id)
-	bal.Add(bal, change)
-	setBalance(id, bal)
-	return bal
-}
-
-func createUser(s *discordgo.Session, id string) (*discordgo.User, error) {
-	user, err := s.User(id)
+func createUser(ctx Context, id string) (User, error) {
+	user, err := ctx.User(id)
 	if err != nil {
-		return nil, err
+		return User{}, err
 	}
-	stmt, err := db.Prepare("SELECT balance FROM users WHERE id=?")
+	err = store.CreateUser(id, config.StartingBalance)
 	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
-	var balanceStr string
-	err = stmt.QueryRow(id).Scan(&balanceStr)
-	if err != nil {
-		stmt2, err := db.Prepare("INSERT INTO users (id, type, balance, games, daily, ff_wins, ff_losses, bj_wins, bj_losses) VALUES (?, 'DEFAULT', ?, 0, 0, 0, 0, 0, 0)")
-		if err != nil {
-			return nil, err
-		}
-		_, err = stmt2.Exec(id, "10000")
-		if err != nil {
-			return nil, err
-		}
-		defer stmt.Close()
+		return User{}, err
 	}
 	return user, nil
 }
 
-func getBet(id string, bet string) *big.Int {
-	balance := getBalance(id)
-	amount := big.NewInt(0)
-	if strings.HasSuffix(bet, "%") {
-		percentage, err := strconv.ParseFloat(strings.TrimSuffix(bet, "%"), 64)
-		if err != nil || percentage < 0 || percentage > 100 {
-			return amount
-		}
-		new(big.Float).Mul(new(big.Float).SetInt(balance), big.NewFloat(percentage*0.01)).Int(amount)
-	} else if bet == "all" {
-		return balance
-	} else if bet == "half" {
-		new(big.Float).Mul(new(big.Float).SetInt(balance), big.NewFloat(0.5)).Int(amount)
-	} else {
-		amt, err := strconv.ParseFloat(bet, 64)
-		if err != nil || amt < 0 {
-			return amount
-		}
-		bi := big.NewInt(int64(amt))
-		if bi.Cmp(balance) == 1 {
-			return balance
-		}
-		amount = bi
+// getBet returns how much of the author's balance the amount is.
+// If the amount is more than they have, it replies and returns ok=false.
+func getBet(ctx Context, bet amount) (amt *big.Int, ok bool, err error) {
+	balance, err := getBalance(ctx.Author().ID)
+	if err != nil {
+		return nil, false, err
 	}
-
-	return amount
+	amt, ok = bet.of(balance)
+	if !ok {
+		ctx.Reply(ctx.Author().Mention + " you only have $" + balance.String() + ".")
+	}
+	return amt, ok, nil
 }
 
-func daily(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	createUser(s, m.Author.ID)
-	stmt, err := db.Prepare("SELECT daily FROM users WHERE id=?")
+func daily(ctx Context, args Args) error {
+	_, err := createUser(ctx, ctx.Author().ID)
 	if err != nil {
-		log.Fatalln("Could not get daily flag:", err)
+		return err
 	}
-	var daily int64
-	err = stmt.QueryRow(m.Author.ID).Scan(&daily)
-	if err != nil {
-		log.Fatalln("Could not get daily flag:", err)
-	}
-	defer stmt.Close()
-
 	tmr := time.Now().AddDate(0, 0, 1)
 	tmr = time.Date(tmr.Year(), tmr.Month(), tmr.Day(), 0, 0, 0, 0, tmr.Location())
-	if time.Unix(daily, 0).Unix() >= time.Now().Unix() {
-		s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" you already claimed your daily supply today! Come back <t:"+fmt.Sprint(tmr.Unix())+":R>")
-		return
-	}
-	newBal := addBalance(m.Author.ID, big.NewInt(2000))
-	s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" you have claimed your daily supply of $2000.\nYou now have $"+newBal.String()+" Come back <t:"+fmt.Sprint(tmr.Unix())+":R>")
-
-	stmt2, err := db.Prepare("UPDATE users SET daily=? WHERE id=?")
-	if err != nil {
-		log.Fatalln("Could not update daily:", err)
+	// Checking, crediting and setting the daily happen at once, so that claiming twice at the same time pays once
+	newBal, err := store.ClaimDaily(ctx.Author().ID, time.Now().Unix(), tmr.Unix(), config.DailyReward)
+	if err == ErrDailyClaimed {
+		ctx.Reply(ctx.Author().Mention + " you already claimed your daily supply today! Come back <t:" + fmt.Sprint(tmr.Unix()) + ":R>")
+		return nil
 	}
-	_, err = stmt2.Exec(tmr.Unix(), m.Author.ID)
 	if err != nil {
-		log.Fatalln("Could not update daily:", err)
+		return fmt.Errorf("could not claim daily of %s: %w", ctx.Author().ID, err)
 	}
-	defer stmt.Close()
+	ctx.Reply(ctx.Author().Mention + " you have claimed your daily supply of $" + config.DailyReward.String() + ".\nYou now have $" + newBal.String() + " Come back <t:" + fmt.Sprint(tmr.Unix()) + ":R>")
+	return nil
 }
 
-func top(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	createUser(s, m.Author.ID)
-	stmt, err := db.Prepare("SELECT COUNT(*) FROM users")
+func top(ctx Context, args Args) error {
+	_, err := createUser(ctx, ctx.Author().ID)
 	if err != nil {
-		log.Fatalln("Could not count users:", err)
+		return err
 	}
-	var count int64
-	err = stmt.QueryRow().Scan(&count)
+	count, err := store.UserCount()
 	if err != nil {
-		log.Fatalln("Could not count users:", err)
+		return fmt.Errorf("could not count users: %w", err)
 	}
-	defer stmt.Close()
 	pages := int(math.Ceil(float64(count) * 0.1))
 
-	page := 1
-	if len(args) > 0 {
-		page, err = strconv.Atoi(args[0])
-		if err != nil {
-			s.ChannelMessageSend(m.ChannelID, "Invalid page number: "+args[0])
-			return
-		}
-		if page < 1 {
-			page = 1
-		}
-		if page > pages {
-			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Exceeded number of pages: %d/%d", page, pages))
-			return
-		}
+	page := args.Page("page")
+	if page > pages {
+		ctx.Reply(fmt.Sprintf("Exceeded number of pages: %d/%d", page, pages))
+		return nil
 	}
 	page--
 
-	stmt2, err := db.Prepare("SELECT id, balance FROM users ORDER BY CAST(balance AS DECIMAL(100, 100)) DESC LIMIT 10 OFFSET ?")
+	users, err := store.TopUsers(page*10, 10)
 	if err != nil {
-		log.Fatalln("Could not get top users:", err)
+		return fmt.Errorf("could not get top users: %w", err)
 	}
-	rows, err := stmt2.Query(page * 10)
-	if err != nil {
-		log.Fatalln("Could not get top users:", err)
-	}
-	defer stmt2.Close()
 
 	message := ""
 	n := page*10 + 1
-	defer rows.Close()
-	for rows.Next() {
-		var id string
-		var bal string
-		err = rows.Scan(&id, &bal)
+	for _, u := range users {
+		user, err := ctx.User(u.ID)
 		if err != nil {
-			log.Fatalln("Could not get top users:", err)
-		}
-		user, err := s.User(id)
-		if err != nil {
-			stmt3, err := db.Prepare("DELETE FROM users WHERE id=?")
+			err = store.DeleteUser(u.ID)
 			if err != nil {
-				log.Fatalln("Could not delete user:", err)
+				return fmt.Errorf("could not delete user %s: %w", u.ID, err)
 			}
-			_, err = stmt3.Exec(id)
-			if err != nil {
-				log.Fatalln("Could not delete user:", err)
-			}
-			defer stmt3.Close()
-			top(s, m, args)
-			return
+			return top(ctx, args)
 		}
-		message += fmt.Sprintf("%d: %s#%s \u27A4 $%s", n, user.Username, user.Discriminator, bal) + "\n"
+		message += fmt.Sprintf("%d: %s \u27A4 $%s", n, user.Name, u.Balance) + "\n"
 		n++
 	}
 
-	s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{},
-		Color:  0xffff00,
-		Fields: []*discordgo.MessageEmbedField{
+	ctx.ReplyEmbed(&Embed{
+		Color: 0xffff00,
+		Fields: []EmbedField{
 			{
 				Name:   fmt.Sprintf("Top %d to %d richest players", page*10+1, n-1),
 				Value:  message,
 				Inline: true,
 			},
 		},
-		Timestamp: time.Now().Format(time.RFC3339),
-		Title:     "Top Players",
+		Title: "Top Players",
 	})
+	return nil
 }
 
-func share(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	if len(args) < 2 {
-		s.ChannelMessageSend(m.ChannelID, "Invalid syntax: `share <amount> <user>`")
-		return
+func share(ctx Context, args Args) error {
+	id := args.UserID("user")
+	if id == ctx.Author().ID {
+		ctx.Reply(ctx.Author().Mention + " I see what you're trying to do but I'm not going to allow it.")
+		return nil
 	}
-	id, err := getID(args[1])
+	user, err := createUser(ctx, id)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" "+args[1]+" is not a valid User ID.\nPlease ping the user or copy their ID and paste it.")
-		return
+		ctx.Reply(ctx.Author().Mention + " " + id + " is not a valid User ID.\nPlease ping the user or copy their ID and paste it.")
+		return nil
 	}
-	createUser(s, id)
-	if id == m.Author.ID {
-		s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" I see what you're trying to do but I'm not going to allow it.")
-		return
+	banned, err := isBanned(id)
+	if err != nil {
+		return err
+	}
+	if banned {
+		ctx.Reply(ctx.Author().Mention + " " + user.Name + " is banned from the economy and cannot receive money.")
+		return nil
 	}
-	amount := getBet(m.Author.ID, args[0])
-	user, err := s.User(id)
+	_, err = createUser(ctx, ctx.Author().ID)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, m.Author.Mention()+" "+args[1]+" is not a valid User ID.\nPlease ping the user or copy their ID and paste it.")
-		return
+		return err
+	}
+	amount, ok, err := getBet(ctx, args.Amount("amount"))
+	if !ok {
+		return err
 	}
-	taxed := new(big.Int)
-	new(big.Float).Mul(new(big.Float).SetInt(amount), big.NewFloat(0.95)).Int(taxed)
+	taxed := fractionOf(amount, 1-config.ShareTax)
 
-	newSenderBal := addBalance(m.Author.ID, new(big.Int).Sub(big.NewInt(0), amount))
-	newReceiverBal := addBalance(id, taxed)
+	balances, err := store.Transact(
+		BalanceChange{ID: ctx.Author().ID, Amount: new(big.Int).Neg(taxed), Reason: reasonShare, Counterparty: id},
+		BalanceChange{ID: ctx.Author().ID, Amount: new(big.Int).Sub(taxed, amount), Reason: reasonTax},
+		BalanceChange{ID: id, Amount: taxed, Reason: reasonShare, Counterparty: ctx.Author().ID},
+	)
+	if err == ErrInsufficientFunds {
+		ctx.Reply(ctx.Author().Mention + " you do not have enough money to share that much.")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not share coins: %w", err)
+	}
+	newSenderBal, newReceiverBal := balances[1], balances[2]
 
-	embed := &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{},
-		Color:  0x00ff00,
-		Fields: []*discordgo.MessageEmbedField{
+	embed := &Embed{
+		Color: 0x00ff00,
+		Fields: []EmbedField{
 			{
 				Name: "Coins have been shared!",
-				Value: fmt.Sprintf("%s sent %s $%d ($%d after 5%% tax)\n%s\u27A4$%d\n%s\u27A4$%d",
-					m.Author.Mention(), user.Mention(), amount, taxed, m.Author.Mention(), newSenderBal, user.Mention(), newReceiverBal),
+				Value: fmt.Sprintf("%s sent %s $%d ($%d after %v%% tax)\n%s\u27A4$%d\n%s\u27A4$%d",
+					ctx.Author().Mention, user.Mention, amount, taxed, config.ShareTax*100, ctx.Author().Mention, newSenderBal, user.Mention, newReceiverBal),
 				Inline: true,
 			},
 		},
-		Timestamp: time.Now().Format(time.RFC3339),
-		Title:     "Sharing",
+		Title: "Sharing",
 	}
-	s.ChannelMessageSendEmbed(m.ChannelID, embed)
-	s.ChannelMessageSendEmbed(getDM(s, m.Author.ID).ID, embed)
-	s.ChannelMessageSendEmbed(getDM(s, user.ID).ID, embed)
+	ctx.ReplyEmbed(embed)
+	// Users with closed DMs still get the reply above, so failing to DM them is not an error
+	ctx.DM(ctx.Author().ID, Response{Embed: embed})
+	ctx.DM(user.ID, Response{Embed: embed})
+	return nil
 }
 
 func getID(mention string) (string, error) {
@@ -662,672 +470,33 @@ func getID(mention string) (string, error) {
 	return id, nil
 }
 
-func getDM(s *discordgo.Session, userID string) *discordgo.Channel {
-	ch, err := s.UserChannelCreate(userID)
-	if err != nil {
-		log.Fatalln("Could not create user channel:", err)
-	}
-	return ch
-}
-
-var cardTypes = []string{"A", "2", "3", "4", "5", "6", "7", "8", "9", "10", "J", "Q", "K"}
-
-type blackjackGame struct {
-	deck  map[string]int
-	hands [][]string
-	msg   *discordgo.Message
-	bet   *big.Int
-	time  int64
-}
-
-var blackjackGames = make(map[string]blackjackGame)
-
-func blackjack(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	createUser(s, m.Author.ID)
-	if len(args) < 1 {
-		s.ChannelMessageSend(m.ChannelID, "Invalid syntax: `blackjack <bet>`")
-		return
-	}
-	existing, exists := blackjackGames[m.Author.ID]
-	if exists {
-		if time.Now().Unix()-existing.time > 10 {
-			delete(blackjackGames, m.Author.ID)
-		} else {
-			s.ChannelMessageSend(m.ChannelID, "You already have a game in progress.")
-			return
-		}
-	}
-
-	bet := getBet(m.Author.ID, args[0])
-	if bet.Cmp(big.NewInt(0)) != 1 {
-		s.ChannelMessageSend(m.ChannelID, "You must bet more than $0.")
-		return
-	}
-	rand.Seed(time.Now().UnixNano())
-
-	deck := map[string]int{
-		"A":  4,
-		"2":  4,
-		"3":  4,
-		"4":  4,
-		"5":  4,
-		"6":  4,
-		"7":  4,
-		"8":  4,
-		"9":  4,
-		"10": 4,
-		"J":  4,
-		"Q":  4,
-		"K":  4,
-	}
-
-	//Set deck to contain 6 decks
-	for i := 0; i < 6; i++ {
-		for _, card := range cardTypes {
-			deck[card] += 4
-		}
-	}
-
-	var dealerHand = make([]string, 0)
-	var playerHand = make([]string, 0)
-
-	dealerHand = append(dealerHand, getRandomCard(&deck))
-	dealerHand = append(dealerHand, getRandomCard(&deck))
-	for {
-		if getHandTotal(&dealerHand) >= 21 {
-			deck[dealerHand[len(dealerHand)-1]]++
-			dealerHand = remove(dealerHand, len(dealerHand)-1)
-		} else if len(dealerHand) < 2 {
-			dealerHand = append(dealerHand, getRandomCard(&deck))
-		} else {
-			break
-		}
+func stats(ctx Context, args Args) error {
+	id := ctx.Author().ID
+	if args.Has("user") {
+		id = args.UserID("user")
 	}
-
-	playerHand = append(playerHand, getRandomCard(&deck))
-	playerHand = append(playerHand, getRandomCard(&deck))
-	//	dealerHand = append(dealerHand, getRandomCard(&deck))
-	if getHandTotal(&playerHand) == 21 {
-		payout := new(big.Int)
-		new(big.Float).Mul(new(big.Float).SetInt(bet), big.NewFloat(1.5)).Int(payout)
-		addBalance(m.Author.ID, payout)
-		s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-			Content: "",
-			Embed: &discordgo.MessageEmbed{
-				Author: &discordgo.MessageEmbedAuthor{},
-				Color:  0x00ff00,
-				Fields: []*discordgo.MessageEmbedField{
-					{
-						Name:   "Player",
-						Value:  generateHandString(&playerHand),
-						Inline: true,
-					},
-					{
-						Name:   "Dealer",
-						Value:  generateHandString(&dealerHand),
-						Inline: true,
-					},
-					{
-						Name:   "Result",
-						Value:  "You got a blackjack! You now have " + getBalance(m.Author.ID).String() + " (" + payout.String() + ").",
-						Inline: false,
-					},
-				},
-				Timestamp: time.Now().Format(time.RFC3339),
-				Title:     "Blackjack - You won!",
-			},
-		})
-		addStat(m.Author.ID, "bj_wins", 1)
-		return
-	}
-
-	// Generate message with discordgo components for Hit, Stand, and Forfeit that the player can interact with
-	msg, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-		Content: "",
-		Embed: &discordgo.MessageEmbed{
-			Author: &discordgo.MessageEmbedAuthor{},
-			Color:  0xffff00,
-			Fields: []*discordgo.MessageEmbedField{
-				{
-					Name:   "Player",
-					Value:  generateHandString(&playerHand),
-					Inline: true,
-				},
-				{
-					Name:   "Dealer",
-					Value:  "`" + dealerHand[0] + "` `?`",
-					Inline: true,
-				},
-			},
-			Timestamp: time.Now().Format(time.RFC3339),
-			Title:     "Blackjack",
-		},
-		Components: []discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					discordgo.Button{
-						Label:    "Hit",
-						Style:    discordgo.SuccessButton,
-						Disabled: false,
-						CustomID: "bj_hit",
-					},
-					discordgo.Button{
-						Label:    "Stand",
-						Style:    discordgo.SuccessButton,
-						Disabled: false,
-						CustomID: "bj_stand",
-					},
-					discordgo.Button{
-						Label:    "Forfeit",
-						Style:    discordgo.DangerButton,
-						Disabled: false,
-						CustomID: "bj_forfeit",
-					},
-				},
-			},
-		},
-	})
-
+	user, err := createUser(ctx, id)
 	if err != nil {
-		log.Fatalln("Could not send message:", err)
-	}
-
-	blackjackGames[m.Author.ID] = blackjackGame{
-		deck:  deck,
-		hands: [][]string{playerHand, dealerHand},
-		msg:   msg,
-		bet:   bet,
-		time:  time.Now().Unix(),
-	}
-}
-
-func blackjackCont(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredMessageUpdate,
-		Data: &discordgo.InteractionResponseData{
-			Flags: 1,
-		},
-	})
-	id := ""
-	if i.GuildID != "" {
-		id = i.Member.User.ID
-	} else {
-		id = i.User.ID
-	}
-	game, exists := blackjackGames[id]
-
-	if exists && i.Message.ID == game.msg.ID {
-		ng := blackjackGame{
-			deck:  game.deck,
-			hands: game.hands,
-			msg:   game.msg,
-			bet:   game.bet,
-			time:  time.Now().Unix(),
-		}
-		blackjackGames[id] = ng
-		game = ng
-		switch i.MessageComponentData().CustomID {
-
-		case "bj_hit":
-			game.hands[0] = append(game.hands[0], getRandomCard(&game.deck))
-			result := "You busted!"
-			color := 0xff0000
-			payout := new(big.Int)
-			win := false
-			var mult *big.Float
-			p := getHandTotal(&game.hands[0])
-			d := getHandTotal(&game.hands[1])
-			if d <= 15 {
-				game.hands[1] = append(game.hands[1], getRandomCard(&game.deck))
-			}
-			d = getHandTotal(&game.hands[1])
-			if p > 21 {
-				if d > 21 {
-					win = true
-					mult = big.NewFloat(0)
-				} else {
-					win = false
-					mult = big.NewFloat(-1)
-				}
-			} else if d == 21 || (len(game.hands[1]) == 5 && d <= 21) {
-				if p == 21 || (len(game.hands[0]) == 5 && p <= 21) {
-					win = true
-					mult = big.NewFloat(0)
-				} else {
-					win = true
-					mult = nil
-				}
-			} else if p == 21 || (len(game.hands[0]) == 5 && p <= 21) {
-				win = true
-				mult = big.NewFloat(1.5)
-			} else if d > 21 {
-				win = true
-				mult = big.NewFloat(1)
-				if p > 21 {
-					win = true
-					mult = big.NewFloat(0)
-				}
-			} else {
-				win = false
-				mult = nil
-			}
-
-			if mult != nil {
-				if win {
-					switch mult.Cmp(big.NewFloat(1)) {
-					case 1:
-						color = 0x00ff00
-						result = "You got a blackjack/charlie!"
-						addStat(id, "bj_wins", 1)
-					case 0:
-						color = 0x00ff00
-						result = "You won"
-						addStat(id, "bj_wins", 1)
-					case -1:
-						color = 0xffff00
-						result = "You tied"
-					}
-					// Payout of bet * multiplier
-					new(big.Float).Mul(new(big.Float).SetInt(game.bet), mult).Int(payout)
-					addBalance(id, payout)
-				} else {
-					// Remove initial bet from balance
-					addBalance(id, payout.Neg(game.bet))
-					addStat(id, "bj_losses", 1)
-				}
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{})
-				s.ChannelMessageEditComplex(&discordgo.MessageEdit{
-					Channel: game.msg.ChannelID,
-					ID:      game.msg.ID,
-					Embeds: []*discordgo.MessageEmbed{
-						{
-							Author: &discordgo.MessageEmbedAuthor{},
-							Color:  color,
-							Fields: []*discordgo.MessageEmbedField{
-								{
-									Name:   "Player",
-									Value:  generateHandString(&game.hands[0]),
-									Inline: true,
-								},
-								{
-									Name:   "Dealer",
-									Value:  generateHandString(&game.hands[1]),
-									Inline: true,
-								},
-								{
-									Name:   "Result",
-									Value:  result + ", You now have " + getBalance(id).String() + " (" + payout.String() + ").",
-									Inline: false,
-								},
-							},
-							Timestamp: time.Now().Format(time.RFC3339),
-							Title:     "Blackjack",
-						},
-					},
-				})
-				delete(blackjackGames, id)
-			} else {
-
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{})
-				s.ChannelMessageEditComplex(&discordgo.MessageEdit{
-					Channel: game.msg.ChannelID,
-					ID:      game.msg.ID,
-					Embeds: []*discordgo.MessageEmbed{
-						{
-							Author: &discordgo.MessageEmbedAuthor{},
-							Color:  0xffff00,
-							Fields: []*discordgo.MessageEmbedField{
-								{
-									Name:   "Player",
-									Value:  generateHandString(&game.hands[0]),
-									Inline: true,
-								},
-								{
-									Name:   "Dealer",
-									Value:  "`" + game.hands[1][0] + "` `?`",
-									Inline: true,
-								},
-							},
-							Timestamp: time.Now().Format(time.RFC3339),
-							Title:     "Blackjack",
-						},
-					},
-					Components: []discordgo.MessageComponent{
-						discordgo.ActionsRow{
-							Components: []discordgo.MessageComponent{
-								discordgo.Button{
-									Label:    "Hit",
-									Style:    discordgo.SuccessButton,
-									Disabled: false,
-									CustomID: "bj_hit",
-								},
-								discordgo.Button{
-									Label: "Stand",
-
-									Style:    discordgo.SuccessButton,
-									Disabled: false,
-									CustomID: "bj_stand",
-								},
-								discordgo.Button{
-									Label:    "Forfeit",
-									Style:    discordgo.DangerButton,
-									Disabled: false,
-									CustomID: "bj_forfeit",
-								},
-							},
-						},
-					},
-				})
-			}
-
-		case "bj_stand":
-			result := "You lost"
-			color := 0xff0000
-			payout := new(big.Int)
-			for {
-				if getHandTotal(&game.hands[1]) <= 15 {
-					game.hands[1] = append(game.hands[1], getRandomCard(&game.deck))
-				} else {
-					break
-				}
-			}
-			win, mult := checkHands(&game.hands[0], &game.hands[1])
-			if mult == nil {
-				if getHandTotal(&game.hands[0]) == getHandTotal(&game.hands[1]) {
-					mult = big.NewFloat(0)
-				} else {
-					win = false
-				}
-			}
-
-			if win {
-				if mult.Cmp(big.NewFloat(1)) >= 0 {
-					color = 0x00ff00
-					result = "You won"
-					addStat(id, "bj_wins", 1)
-				} else {
-					color = 0xffff00
-					result = "You tied"
-				}
-				// Payout of bet * multiplier
-				new(big.Float).Mul(new(big.Float).SetInt(game.bet), mult).Int(payout)
-				addBalance(id, payout)
-			} else {
-				// Remove initial bet from balance
-				addBalance(id, payout.Neg(game.bet))
-				addStat(id, "bj_losses", 1)
-			}
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{})
-			s.ChannelMessageEditComplex(&discordgo.MessageEdit{
-				Channel: game.msg.ChannelID,
-				ID:      game.msg.ID,
-				Embeds: []*discordgo.MessageEmbed{
-					{
-						Author: &discordgo.MessageEmbedAuthor{},
-						Color:  color,
-						Fields: []*discordgo.MessageEmbedField{
-							{
-								Name:   "Player",
-								Value:  generateHandString(&game.hands[0]),
-								Inline: true,
-							},
-							{
-								Name:   "Dealer",
-								Value:  generateHandString(&game.hands[1]),
-								Inline: true,
-							},
-							{
-								Name:   "Result",
-								Value:  result + ", You now have " + getBalance(id).String() + " (" + payout.String() + ").",
-								Inline: false,
-							},
-						},
-						Timestamp: time.Now().Format(time.RFC3339),
-						Title:     "Blackjack - " + result,
-					},
-				},
-			})
-			delete(blackjackGames, id)
-
-		case "bj_forfeit":
-			// Remove initial bet from balance
-			addBalance(i.User.ID, new(big.Int).Neg(game.bet))
-			// Add one to SQLite Table `stats` for `bj_losses`
-
-			s.ChannelMessageEditComplex(&discordgo.MessageEdit{
-				Channel: game.msg.ChannelID,
-				ID:      game.msg.ID,
-				Embeds: []*discordgo.MessageEmbed{
-					{
-						Author: &discordgo.MessageEmbedAuthor{},
-						Color:  0x00ff00,
-						Fields: []*discordgo.MessageEmbedField{
-							{
-								Name:   "Player",
-								Value:  generateHandString(&game.hands[0]),
-								Inline: true,
-							},
-							{
-								Name:   "Dealer",
-								Value:  generateHandString(&game.hands[1]),
-								Inline: true,
-							},
-							{
-								Name:   "Result",
-								Value:  "You forfeited, You now have " + getBalance(i.User.ID).String() + "(-" + game.bet.String() + ").",
-								Inline: false,
-							},
-						},
-						Timestamp: time.Now().Format(time.RFC3339),
-						Title:     "Blackjack - You forfeited",
-					},
-				},
-			})
-			delete(blackjackGames, id)
-		}
-
-	} else {
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "This is not your game!",
-				Flags:   2,
-			},
-		})
-	}
-}
-
-func getRandomCard(deck *map[string]int) string {
-	rand.Seed(time.Now().UnixNano())
-	for {
-		card := cardTypes[rand.Intn(len(cardTypes))]
-		if (*deck)[card] > 0 {
-			(*deck)[card]--
-			return card
-		}
-	}
-}
-
-func generateHandString(hand *[]string) string {
-	var handString string
-	for _, card := range *hand {
-		handString += "`" + card + "` "
-	}
-	return handString + "\nTotal: " + strconv.Itoa(getHandTotal(hand))
-}
-
-func getHandTotal(hand *[]string) int {
-	cardValues := map[string]int{
-		"2":  2,
-		"3":  3,
-		"4":  4,
-		"5":  5,
-		"6":  6,
-		"7":  7,
-		"8":  8,
-		"9":  9,
-		"10": 10,
-		"J":  10,
-		"Q":  10,
-		"K":  10,
-	}
-	var total int
-	var aces int
-	// Ace is 11 unless it would make the total go over 21
-	// Due to this, its value should only be calculated after the rest.
-	for _, card := range *hand {
-		if card == "A" {
-			total += 11
-			aces++
-		} else {
-			total += cardValues[card]
-		}
-	}
-	for i := 0; i < aces; i++ {
-		if total > 21 {
-			total -= 10
-		}
-	}
-	return total
-}
-
-func checkHands(player *[]string, dealer *[]string) (bool, *big.Float) {
-	// If the player lost, return false
-	// If the the player has a blackjack, a push or has 5 cards without busting, the player wins 1.5x the bet so 1.5 should be returned.
-	// If the player's hand is a bust, the player loses all of his bet so -1 should be returned.
-	p := getHandTotal(player)
-	d := getHandTotal(dealer)
-
-	if p > 21 && d > 21 {
-		return true, big.NewFloat(0)
-	}
-
-	if p > 21 {
-		return false, big.NewFloat(-1)
-	}
-
-	if d == 21 || (len(*dealer) == 5 && d <= 21) {
-		if p == 21 || (len(*player) == 5 && p <= 21) {
-			return true, big.NewFloat(0)
-		}
-		return false, big.NewFloat(-1)
-	}
-
-	if p == 21 {
-		return true, big.NewFloat(1.5)
+		ctx.Reply("Could not find user.")
+		return nil
 	}
-
-	if len(*player) == 5 && p <= 21 {
-		return true, big.NewFloat(1.5)
-	}
-
-	if p > d {
-		return true, big.NewFloat(1)
-	}
-
-	if d > 21 {
-		return true, big.NewFloat(1)
-	}
-
-	if p == d {
-		return true, big.NewFloat(0)
-	}
-
-	//	if getHandTotal(player) == getHandTotal(dealer) {
-	//		return true, big.NewFloat(0)
-	//	}
-
-	return true, nil
-}
-
-func interact(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	switch i.Type {
-	case discordgo.InteractionMessageComponent:
-		if strings.HasPrefix(i.MessageComponentData().CustomID, "bj_") {
-			blackjackCont(s, i)
-		}
-	}
-}
-
-// https://stackoverflow.com/a/37335777 by T. Claverie under CC BY-SA 4.0
-func remove(s []string, i int) []string {
-	s[i] = s[len(s)-1]
-	return s[:len(s)-1]
-}
-
-var autoInvalidatorRunning = false
-
-func autoInvalidator(s *discordgo.Session) {
-	for {
-		time.Sleep(time.Second)
-		for id, game := range blackjackGames {
-			if time.Now().Unix()-game.time > 10 {
-				// Remove initial bet from balance
-				addBalance(id, new(big.Int).Neg(game.bet))
-				s.ChannelMessageEditComplex(&discordgo.MessageEdit{
-					Channel: game.msg.ChannelID,
-					ID:      game.msg.ID,
-					Embeds: []*discordgo.MessageEmbed{
-						{
-							Author: &discordgo.MessageEmbedAuthor{},
-							Color:  0xff0000,
-							Fields: []*discordgo.MessageEmbedField{
-								{
-									Name:   "Player",
-									Value:  generateHandString(&game.hands[0]),
-									Inline: true,
-								},
-								{
-									Name:   "Dealer",
-									Value:  generateHandString(&game.hands[1]),
-									Inline: true,
-								},
-								{
-									Name:   "Result",
-									Value:  "You timed out. You lost " + game.bet.String() + ", and now have " + getBalance(id).String() + ".",
-									Inline: false,
-								},
-							},
-							Timestamp: time.Now().Format(time.RFC3339),
-							Title:     "Blackjack - Timeout",
-						},
-					},
-				})
-				delete(blackjackGames, id)
-			}
-		}
-	}
-}
-
-func stats(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	var id string
-	if len(args) == 0 {
-		id = m.Author.ID
-	} else {
-		iid, err := getID(args[0])
-		id = iid
-		if err != nil {
-			s.ChannelMessageSend(m.ChannelID, "Could not find user.")
-			return
-		}
-	}
-	user, err := createUser(s, id)
+	displayName := user.Name
+	balance, err := getBalance(id)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Could not find user.")
-		return
+		return err
 	}
-	displayName := user.Username + "#" + user.Discriminator
-	balance := getBalance(id)
 
-	ffWins := getStat(id, "ff_wins")
-	ffLosses := getStat(id, "ff_losses")
-	bjWins := getStat(id, "bj_wins")
-	bjLosses := getStat(id, "bj_losses")
+	ffWins := getStat(logFor(ctx), id, "ff_wins")
+	ffLosses := getStat(logFor(ctx), id, "ff_losses")
+	bjWins := getStat(logFor(ctx), id, "bj_wins")
+	bjLosses := getStat(logFor(ctx), id, "bj_losses")
+	insuranceWins := getStat(logFor(ctx), id, "bj_insurance_wins")
+	insuranceLosses := getStat(logFor(ctx), id, "bj_insurance_losses")
+	insurance := "Insurance: " + strconv.Itoa(insuranceWins) + " won, " + strconv.Itoa(insuranceLosses) + " lost"
 
-	s.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{},
-		Color:  0x00ff00,
-		Fields: []*discordgo.MessageEmbedField{
+	ctx.ReplyEmbed(&Embed{
+		Color: 0x00ff00,
+		Fields: []EmbedField{
 			{
 				Name:   "User",
 				Value:  displayName,
@@ -1345,36 +514,25 @@ func stats(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
 			},
 			{
 				Name:   "Blackjack",
-				Value:  strconv.Itoa(bjWins+bjLosses) + " total\n" + strconv.Itoa(bjWins) + " wins, " + strconv.Itoa(bjLosses) + " losses (" + strconv.FormatFloat(float64(bjWins)/float64(bjWins+bjLosses)*100, 'f', 2, 64) + "%)",
+				Value:  strconv.Itoa(bjWins+bjLosses) + " total\n" + strconv.Itoa(bjWins) + " wins, " + strconv.Itoa(bjLosses) + " losses (" + strconv.FormatFloat(float64(bjWins)/float64(bjWins+bjLosses)*100, 'f', 2, 64) + "%)\n" + insurance,
 				Inline: false,
 			},
 		},
 	})
+	return nil
 }
 
-func addStat(id string, stat string, d int) {
-	stmt, err := db.Prepare("UPDATE users SET " + stat + "=? WHERE id=?")
+func addStat(l logger, id string, stat string, d int) {
+	err := store.AddStat(id, stat, d)
 	if err != nil {
-		log.Println(err)
-		return
-	}
-	_, err = stmt.Exec(d+getStat(id, stat), id)
-	if err != nil {
-		log.Println(err)
-		return
+		l.Error("Could not add to stat", "stat", stat, "error", err)
 	}
 }
 
-func getStat(id, stat string) int {
-	stmt, err := db.Prepare("SELECT `" + stat + "` FROM users WHERE id=?")
-	if err != nil {
-		log.Println(err)
-		return 0
-	}
-	var c int
-	err = stmt.QueryRow(id).Scan(&c)
+func getStat(l logger, id, stat string) int {
+	c, err := store.Stat(id, stat)
 	if err != nil {
-		log.Println(err)
+		l.Error("Could not get stat", "stat", stat, "error", err)
 		return 0
 	}
 	return c
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is written out in the Prometheus text format when metrics are scraped.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// metrics are all the metrics, they register themselves when they are created.
+var metrics []metric
+
+var (
+	commandsTotal = newCounterVec("risk_commands_total",
+		"Commands invoked, by command name and outcome (ok, error or panic).", "command", "outcome")
+	commandDuration = newHistogramVec("risk_command_duration_seconds",
+		"How long commands took to run, including their middleware.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "command")
+	blackjackSessionsActive = newGaugeFunc("risk_blackjack_sessions",
+		"Blackjack games in progress.", func() float64 {
+			if blackjackSessions == nil {
+				return 0
+			}
+			return float64(blackjackSessions.Count())
+		})
+	moneyMinted = newCounterVec("risk_money_minted_total",
+		"Money created out of nothing, by source.", "source")
+	moneyDestroyed = newCounterVec("risk_money_destroyed_total",
+		"Money taken out of the economy, by sink.", "sink")
+	moneyWagered = newCounterVec("risk_wagered_total",
+		"Money bet on games, by game.", "game")
+	moneyPaidOut = newCounterVec("risk_paid_out_total",
+		"Money paid back to players by games, including their bets, by game.", "game")
+	sqliteQueryDuration = newHistogramVec("risk_sqlite_query_duration_seconds",
+		"How long queries to sqlite.db took, by store operation.",
+		[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}, "operation")
+)
+
+// series is the value of a metric for one combination of label values.
+type series struct {
+	labels []string
+	value  float64
+	// buckets, sum and count are only used by histograms.
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// metricVec holds the series of a metric, keyed by their label values.
+type metricVec struct {
+	metricName string
+	help       string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string]*series
+}
+
+func (v *metricVec) name() string {
+	return v.metricName
+}
+
+// with returns the series with the label values, creating it if needed. v.mu must be held.
+func (v *metricVec) with(labels []string) *series {
+	if len(labels) != len(v.labels) {
+		panic(fmt.Sprintf("%s has %d labels, got %d", v.metricName, len(v.labels), len(labels)))
+	}
+	key := strings.Join(labels, "\xff")
+	s, exists := v.series[key]
+	if !exists {
+		s = &series{labels: labels}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by their label values, so that scrapes are stable. v.mu must be held.
+func (v *metricVec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sorted := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, v.series[key])
+	}
+	return sorted
+}
+
+func (v *metricVec) writeHeader(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, v.help, v.metricName, kind)
+}
+
+// counterVec is a value that only goes up.
+type counterVec struct {
+	metricVec
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	c := &counterVec{metricVec{metricName: name, help: help, labels: labels, series: make(map[string]*series)}}
+	metrics = append(metrics, c)
+	return c
+}
+
+func (c *counterVec) add(d float64, labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.with(labels).value += d
+}
+
+// addInt adds an amount of money, which may be too large to be represented exactly.
+func (c *counterVec) addInt(d *big.Int, labels ...string) {
+	f, _ := new(big.Float).SetInt(d).Float64()
+	c.add(math.Abs(f), labels...)
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, s.labels), formatFloat(s.value))
+	}
+}
+
+// histogramVec counts observations, such as durations, in buckets.
+type histogramVec struct {
+	metricVec
+	// bounds are the upper bounds of the buckets in increasing order, the +Inf bucket is implied.
+	bounds []float64
+}
+
+func newHistogramVec(name string, help string, bounds []float64, labels ...string) *histogramVec {
+	h := &histogramVec{metricVec{metricName: name, help: help, labels: labels, series: make(map[string]*series)}, bounds}
+	metrics = append(metrics, h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.with(labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+	for i, bound := range h.bounds {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// since observes the time since start in seconds, it is meant to be deferred.
+func (h *histogramVec) since(start time.Time, labels ...string) {
+	h.observe(time.Since(start).Seconds(), labels...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	names := append(append([]string{}, h.labels...), "le")
+	for _, s := range h.sorted() {
+		for i, bound := range h.bounds {
+			labels := formatLabels(names, append(append([]string{}, s.labels...), formatFloat(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labels, s.buckets[i])
+		}
+		labels := formatLabels(names, append(append([]string{}, s.labels...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labels, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, formatLabels(h.labels, s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, formatLabels(h.labels, s.labels), s.count)
+	}
+}
+
+// gaugeFunc is a value that can go up and down, it is read when metrics are scraped.
+type gaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+func newGaugeFunc(name string, help string, fn func() float64) *gaugeFunc {
+	g := &gaugeFunc{name, help, fn}
+	metrics = append(metrics, g)
+	return g
+}
+
+func (g *gaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.metricName, g.help, g.metricName, g.metricName, formatFloat(g.fn()))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// writeMetrics writes every metric in the Prometheus text format, ordered by name.
+func writeMetrics(w io.Writer) {
+	sorted := append([]metric{}, metrics...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name() < sorted[j].name()
+	})
+	for _, m := range sorted {
+		m.write(w)
+	}
+}
+
+// serveMetrics serves the metrics on addr at /metrics until the listener fails.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w)
+	})
+	rootLogger.Info("Serving metrics", "addr", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		rootLogger.Error("Could not serve metrics", "error", err)
+	}
+}
+
+// metered counts every invocation of the command by its outcome and records how long it took.
+func metered(name string, next handler) handler {
+	return func(ctx Context, args Args) error {
+		start := time.Now()
+		defer commandDuration.since(start, name)
+		defer func() {
+			// The panic is left for safely to report
+			if r := recover(); r != nil {
+				commandsTotal.add(1, name, "panic")
+				panic(r)
+			}
+		}()
+		err := next(ctx, args)
+		if err != nil {
+			commandsTotal.add(1, name, "error")
+		} else {
+			commandsTotal.add(1, name, "ok")
+		}
+		return err
+	}
+}
+
+// meteredStore counts the money that flows through the store by the reason it was moved for.
+type meteredStore struct {
+	Store
+}
+
+func (ms meteredStore) Transact(changes ...BalanceChange) ([]*big.Int, error) {
+	balances, err := ms.Store.Transact(changes...)
+	if err == nil {
+		countMoney(changes)
+	}
+	return balances, err
+}
+
+func (ms meteredStore) ClaimDaily(id string, now int64, next int64, reward *big.Int) (*big.Int, error) {
+	balance, err := ms.Store.ClaimDaily(id, now, next, reward)
+	if err == nil {
+		countMoney([]BalanceChange{{ID: id, Amount: reward, Reason: reasonDaily}})
+	}
+	return balance, err
+}
+
+// ApplyAdminChange counts the money admins create or take away, which never goes through Transact.
+func (ms meteredStore) ApplyAdminChange(change AdminChange) (*big.Int, *big.Int, error) {
+	old, balance, err := ms.Store.ApplyAdminChange(change)
+	if err == nil && change.changesBalance() {
+		countMoney([]BalanceChange{{ID: change.Action.UserID, Amount: new(big.Int).Sub(balance, old), Reason: change.LedgerReason}})
+	}
+	return old, balance, err
+}
+
+// countMoney counts the balance changes in the money metrics.
+// Games take the bet as it is placed and pay back the bet along with the winnings. Refunded bets count as paid out,
+// so that the house's take is always wagered minus paid out.
+func countMoney(changes []BalanceChange) {
+	for _, change := range changes {
+		switch change.Reason {
+		case reasonDaily:
+			moneyMinted.addInt(change.Amount, "daily")
+		case reasonTax:
+			moneyDestroyed.addInt(change.Amount, "tax")
+		case reasonAdminSet, reasonAdminAdd, reasonAdminReset:
+			if change.Amount.Sign() > 0 {
+				moneyMinted.addInt(change.Amount, "admin")
+			} else {
+				moneyDestroyed.addInt(change.Amount, "admin")
+			}
+		case reasonBlackjackBet, reasonBlackjackDouble, reasonBlackjackSplit, reasonBlackjackInsurance:
+			moneyWagered.addInt(change.Amount, "blackjack")
+		case reasonBlackjackNatural, reasonBlackjackWin, reasonBlackjackPush, reasonBlackjackLoss, reasonBlackjackRefund,
+			reasonBlackjackEvenMoney, reasonBlackjackInsuranceWin:
+			moneyPaidOut.addInt(change.Amount, "blackjack")
+		case reasonFiftyFiftyBet:
+			moneyWagered.addInt(change.Amount, "50/50")
+		case reasonFiftyFiftyWin, reasonFiftyFiftyLoss:
+			moneyPaidOut.addInt(change.Amount, "50/50")
+		}
+	}
+}
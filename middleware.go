@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// middleware wraps the handler of the command named name, usually to decide whether next runs at all.
+// Commands opt into middleware through their registry entry, see command.middleware.
+type middleware func(name string, next handler) handler
+
+// globalMiddleware runs around every command.
+var globalMiddleware = []middleware{metered, logged, guildEnabled}
+
+// chain wraps h in the middleware, the first middleware runs first.
+func chain(name string, h handler, mws ...middleware) handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](name, h)
+	}
+	return h
+}
+
+// dispatch runs the command invoked by alias with its middleware.
+// The arguments are parsed after globalMiddleware, so that invocations with invalid arguments never reach the
+// command's own middleware and are not put on cooldown.
+func dispatch(ctx Context, alias string, raw []string) {
+	cmd, exists := lookupCommand(alias)
+	if !exists {
+		return
+	}
+	mws := append(append([]middleware{}, globalMiddleware...), parsed)
+	mws = append(mws, cmd.middleware()...)
+	safely(ctx, cmd.Name, func(ctx Context) error {
+		return chain(cmd.Name, cmd.Run, mws...)(ctx, Args{Raw: raw})
+	})
+}
+
+// dispatchComponent runs the handler of the button clicked on msg with the middleware of the command that sent it.
+// The button's ID is passed on as the only raw argument, so that it is logged.
+func dispatchComponent(ctx Context, c component, buttonID string, msg MessageRef) {
+	cmd := commandsByAlias[c.Command]
+	mws := append(append([]middleware{}, globalMiddleware...), cmd.guards()...)
+	h := func(ctx Context, args Args) error {
+		return c.Handle(ctx, buttonID, msg)
+	}
+	safely(ctx, cmd.Name, func(ctx Context) error {
+		return chain(cmd.Name, h, mws...)(ctx, Args{Raw: []string{buttonID}})
+	})
+}
+
+// parsed parses the raw arguments according to the command's signature, replying with its usage if they do not match.
+func parsed(name string, next handler) handler {
+	sig := commandsByAlias[name].Args
+	return func(ctx Context, args Args) error {
+		args, err := sig.parse(args.Raw)
+		if uerr, ok := err.(usageError); ok {
+			ctx.Reply(uerr.msg + "\nUsage: `" + sig.usage(name) + "`")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return next(ctx, args)
+	}
+}
+
+// logged logs every invocation of the command along with how long it took.
+func logged(name string, next handler) handler {
+	return func(ctx Context, args Args) error {
+		start := time.Now()
+		err := next(ctx, args)
+		logFor(ctx).Info("Ran command", "args", args.Raw, "duration_ms", float64(time.Since(start).Microseconds())/1000)
+		return err
+	}
+}
+
+// guildEnabled refuses commands the guild disabled in config.DisabledCommands.
+func guildEnabled(name string, next handler) handler {
+	return func(ctx Context, args Args) error {
+		for _, disabled := range config.DisabledCommands[ctx.GuildID()] {
+			if commandName(disabled) == name {
+				ctx.Reply("`" + name + "` is disabled in this server.")
+				return nil
+			}
+		}
+		return next(ctx, args)
+	}
+}
+
+// requireManageGuild refuses the command to users who cannot change the guild's settings.
+func requireManageGuild(name string, next handler) handler {
+	return func(ctx Context, args Args) error {
+		if !ctx.CanManageGuild() {
+			ctx.Reply(ctx.Author().Mention + " you do not have the necessary permissions to use `" + name + "` (Manage Server).")
+			return nil
+		}
+		return next(ctx, args)
+	}
+}
+
+// errorEmbed is shown to the user when a command fails because of an internal error.
+var errorEmbed = &Embed{
+	Color: 0xff0000,
+	Fields: []EmbedField{
+		{
+			Name:   "Something went wrong",
+			Value:  "An internal error occurred while running this command, please try again later.",
+			Inline: true,
+		},
+	},
+	Title: "Error",
+}
+
+// safely runs f on behalf of the invocation named name, with a context that carries its logger. Any error f returns
+// or panic it causes is logged and reported to the user, so that a single failing command cannot take down the bot.
+func safely(ctx Context, name string, f func(ctx Context) error) {
+	rc := withRequest(ctx, name)
+	defer func() {
+		if r := recover(); r != nil {
+			rc.log.Error("Command panicked", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			ctx.ReplyEmbed(errorEmbed)
+		}
+	}()
+	err := f(rc)
+	if err != nil {
+		rc.log.Error("Command failed", "error", err)
+		ctx.ReplyEmbed(errorEmbed)
+	}
+}
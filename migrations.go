@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// migration is a single schema change.
+// Every migration must be safe to run against a database that already has its changes,
+// since older deployments were patched by hand before schema_version existed.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations are applied in order, new migrations must be appended with the next version.
+var migrations = []migration{
+	{1, "create servers, users and cooldowns tables", func(tx *sql.Tx) error {
+		return execAll(tx,
+			"CREATE TABLE IF NOT EXISTS `servers` (`id` TEXT NOT NULL PRIMARY KEY, `type` TEXT NOT NULL DEFAULT 'DEFAULT', `prefix` TEXT NOT NULL DEFAULT ',');",
+			"CREATE TABLE IF NOT EXISTS `users` (`id` TEXT NOT NULL PRIMARY KEY, `type` TEXT NOT NULL DEFAULT 'DEFAULT', `balance` TEXT NOT NULL DEFAULT '0', `games` INTEGER NOT NULL DEFAULT 0, `daily` INTEGER NOT NULL DEFAULT 0, `ff_wins` INTEGER NOT NULL DEFAULT 0, `ff_losses` INTEGER NOT NULL DEFAULT 0, `bj_wins` INTEGER NOT NULL DEFAULT 0, `bj_losses` INTEGER NOT NULL DEFAULT 0);",
+			"CREATE TABLE IF NOT EXISTS `cooldowns` (`user_id` TEXT NOT NULL PRIMARY KEY, `balance` INTEGER NOT NULL DEFAULT 0, `top` INTEGER NOT NULL DEFAULT 0, `blackjack` INTEGER NOT NULL DEFAULT 0, `half` INTEGER NOT NULL DEFAULT 0, `scratch` INTEGER NOT NULL DEFAULT 0);",
+		)
+	}},
+	{2, "add columns missing from databases created by older versions", func(tx *sql.Tx) error {
+		columns := []struct {
+			table      string
+			column     string
+			definition string
+		}{
+			{"servers", "type", "TEXT NOT NULL DEFAULT 'DEFAULT'"},
+			{"servers", "prefix", "TEXT NOT NULL DEFAULT ','"},
+			{"users", "type", "TEXT NOT NULL DEFAULT 'DEFAULT'"},
+			{"users", "balance", "TEXT NOT NULL DEFAULT '0'"},
+			{"users", "games", "INTEGER NOT NULL DEFAULT 0"},
+			{"users", "daily", "INTEGER NOT NULL DEFAULT 0"},
+			{"users", "ff_wins", "INTEGER NOT NULL DEFAULT 0"},
+			{"users", "ff_losses", "INTEGER NOT NULL DEFAULT 0"},
+			{"users", "bj_wins", "INTEGER NOT NULL DEFAULT 0"},
+			{"users", "bj_losses", "INTEGER NOT NULL DEFAULT 0"},
+			{"cooldowns", "balance", "INTEGER NOT NULL DEFAULT 0"},
+			{"cooldowns", "top", "INTEGER NOT NULL DEFAULT 0"},
+			{"cooldowns", "blackjack", "INTEGER NOT NULL DEFAULT 0"},
+			{"cooldowns", "half", "INTEGER NOT NULL DEFAULT 0"},
+			{"cooldowns", "scratch", "INTEGER NOT NULL DEFAULT 0"},
+		}
+		for _, c := range columns {
+			err := addColumn(tx, c.table, c.column, c.definition)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{3, "create ledger table", func(tx *sql.Tx) error {
+		return execAll(tx,
+			"CREATE TABLE IF NOT EXISTS `ledger` (`id` INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, `user_id` TEXT NOT NULL, `amount` TEXT NOT NULL, `balance` TEXT NOT NULL, `reason` TEXT NOT NULL, `counterparty` TEXT NOT NULL DEFAULT '', `time` INTEGER NOT NULL);",
+			"CREATE INDEX IF NOT EXISTS `ledger_user_id` ON `ledger` (`user_id`, `id`);",
+		)
+	}},
+	{4, "create games table", func(tx *sql.Tx) error {
+		return execAll(tx,
+			"CREATE TABLE IF NOT EXISTS `games` (`user_id` TEXT NOT NULL, `kind` TEXT NOT NULL, `state` BLOB NOT NULL, `bet` TEXT NOT NULL, `channel_id` TEXT NOT NULL, `message_id` TEXT NOT NULL, `last_active` INTEGER NOT NULL, PRIMARY KEY (`user_id`, `kind`));",
+		)
+	}},
+	{5, "create seeds and fair_games tables", func(tx *sql.Tx) error {
+		return execAll(tx,
+			"CREATE TABLE IF NOT EXISTS `seeds` (`user_id` TEXT NOT NULL PRIMARY KEY, `server_seed` TEXT NOT NULL, `client_seed` TEXT NOT NULL, `nonce` INTEGER NOT NULL DEFAULT 0);",
+			"CREATE TABLE IF NOT EXISTS `fair_games` (`id` INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, `user_id` TEXT NOT NULL, `kind` TEXT NOT NULL, `server_seed` TEXT NOT NULL, `client_seed` TEXT NOT NULL, `nonce` INTEGER NOT NULL, `rolls` TEXT NOT NULL DEFAULT '', `result` TEXT NOT NULL DEFAULT '', `time` INTEGER NOT NULL);",
+		)
+	}},
+	{6, "add cooldowns of fiftyfifty, share, history and stats", func(tx *sql.Tx) error {
+		for _, column := range []string{"fiftyfifty", "share", "history", "stats"} {
+			err := addColumn(tx, "cooldowns", column, "INTEGER NOT NULL DEFAULT 0")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{7, "create admin_actions table", func(tx *sql.Tx) error {
+		return execAll(tx,
+			"CREATE TABLE IF NOT EXISTS `admin_actions` (`id` INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, `admin_id` TEXT NOT NULL, `user_id` TEXT NOT NULL, `action` TEXT NOT NULL, `detail` TEXT NOT NULL, `reason` TEXT NOT NULL, `time` INTEGER NOT NULL);",
+			"CREATE INDEX IF NOT EXISTS `admin_actions_user_id` ON `admin_actions` (`user_id`, `id`);",
+		)
+	}},
+	{8, "add blackjack insurance stats", func(tx *sql.Tx) error {
+		for _, column := range []string{"bj_insurance_wins", "bj_insurance_losses"} {
+			err := addColumn(tx, "users", column, "INTEGER NOT NULL DEFAULT 0")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{9, "add blackjack rules of servers", func(tx *sql.Tx) error {
+		return addColumn(tx, "servers", "blackjack_rules", "TEXT NOT NULL DEFAULT ''")
+	}},
+}
+
+// appliedMigration is a row of the schema_version table.
+type appliedMigration struct {
+	version   int
+	name      string
+	appliedAt int64
+}
+
+func execAll(tx *sql.Tx, statements ...string) error {
+	for _, statement := range statements {
+		_, err := tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	rows, err := tx.Query("PRAGMA table_info(`" + table + "`)")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid int
+		var name string
+		var ctype string
+		var notNull int
+		var dflt sql.NullString
+		var pk int
+		err = rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk)
+		if err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumn adds the column to the table unless it already exists.
+func addColumn(tx *sql.Tx, table string, column string, definition string) error {
+	exists, err := hasColumn(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	rootLogger.Info("Adding column", "table", table, "column", column)
+	_, err = tx.Exec("ALTER TABLE `" + table + "` ADD COLUMN `" + column + "` " + definition)
+	return err
+}
+
+func appliedMigrations(db *sql.DB) ([]appliedMigration, error) {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS `schema_version` (`version` INTEGER NOT NULL PRIMARY KEY, `name` TEXT NOT NULL, `applied_at` INTEGER NOT NULL);")
+	if err != nil {
+		return nil, errors.New("Could not create schema_version table: " + err.Error())
+	}
+	rows, err := db.Query("SELECT version, name, applied_at FROM schema_version ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make([]appliedMigration, 0)
+	for rows.Next() {
+		var m appliedMigration
+		err = rows.Scan(&m.version, &m.name, &m.appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+// migrate applies every migration newer than the database's schema version.
+func migrate(db *sql.DB) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	current := 0
+	if len(applied) > 0 {
+		current = applied[len(applied)-1].version
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		rootLogger.Info("Applying migration", "version", m.version, "name", m.name)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		err = m.up(tx)
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", m.version, m.name, time.Now().Unix())
+		}
+		if err != nil {
+			tx.Rollback()
+			return errors.New("Could not apply migration " + strconv.Itoa(m.version) + ": " + err.Error())
+		}
+		err = tx.Commit()
+		if err != nil {
+			return errors.New("Could not apply migration " + strconv.Itoa(m.version) + ": " + err.Error())
+		}
+		rootLogger.Info("Applied migration", "version", m.version)
+	}
+	return nil
+}
+
+// printMigrations lists every known migration and whether it has been applied.
+func printMigrations(db *sql.DB) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	appliedAt := make(map[int]int64)
+	for _, m := range applied {
+		appliedAt[m.version] = m.appliedAt
+	}
+	for _, m := range migrations {
+		if t, ok := appliedAt[m.version]; ok {
+			fmt.Printf("%3d  %s  (applied %s)\n", m.version, m.name, time.Unix(t, 0).Format(time.RFC3339))
+		} else {
+			fmt.Printf("%3d  %s  (pending)\n", m.version, m.name)
+		}
+	}
+	return nil
+}
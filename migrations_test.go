@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	setupLogging("error", "stderr")
+	db, err := openSQLite(t.TempDir() + "/sqlite.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+func execTest(t *testing.T, db *sql.DB, statements ...string) {
+	t.Helper()
+	for _, statement := range statements {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+}
+
+func columns(t *testing.T, db *sql.DB, table string) map[string]int {
+	t.Helper()
+	rows, err := db.Query("PRAGMA table_info(`" + table + "`)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	found := make(map[string]int)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		err = rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found[name]++
+	}
+	return found
+}
+
+// checkMigrated checks that every migration is recorded once and in order, and that the tables have every column.
+func checkMigrated(t *testing.T, db *sql.DB) {
+	t.Helper()
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("%d migrations applied, want %d", len(applied), len(migrations))
+	}
+	for i, m := range applied {
+		if m.version != migrations[i].version || m.name != migrations[i].name {
+			t.Errorf("migration %d recorded as %d %q, want %d %q", i, m.version, m.name, migrations[i].version, migrations[i].name)
+		}
+	}
+
+	want := map[string][]string{
+		"servers":   {"id", "type", "prefix", "blackjack_rules"},
+		"users":     append([]string{"id", "type", "balance", "daily"}, statNames...),
+		"cooldowns": {"user_id", "balance", "top", "blackjack", "half", "scratch", "fiftyfifty", "share", "history", "stats"},
+	}
+	for table, cols := range want {
+		found := columns(t, db, table)
+		for _, col := range cols {
+			if found[col] != 1 {
+				t.Errorf("%s has %d %s columns, want 1", table, found[col], col)
+			}
+		}
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	checkMigrated(t, db)
+}
+
+// Databases of older versions have no schema_version, and some had columns of later migrations added by hand.
+func TestMigrateHandPatchedDatabase(t *testing.T) {
+	db := openTestDB(t)
+	execTest(t, db,
+		"CREATE TABLE `servers` (`id` TEXT NOT NULL PRIMARY KEY, `prefix` TEXT NOT NULL DEFAULT ',');",
+		"CREATE TABLE `users` (`id` TEXT NOT NULL PRIMARY KEY, `balance` TEXT NOT NULL DEFAULT '0', `bj_wins` INTEGER NOT NULL DEFAULT 0, `bj_insurance_wins` INTEGER NOT NULL DEFAULT 0);",
+		"CREATE TABLE `cooldowns` (`user_id` TEXT NOT NULL PRIMARY KEY, `blackjack` INTEGER NOT NULL DEFAULT 0, `share` INTEGER NOT NULL DEFAULT 0);",
+		"INSERT INTO servers (id, prefix) VALUES ('1', '!');",
+		"INSERT INTO users (id, balance, bj_wins, bj_insurance_wins) VALUES ('2', '123456789012345678901234567890', 7, 3);",
+	)
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	checkMigrated(t, db)
+
+	var prefix, balance string
+	var bjWins, insuranceWins, ffWins int
+	err := db.QueryRow("SELECT prefix FROM servers WHERE id='1'").Scan(&prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT balance, bj_wins, bj_insurance_wins, ff_wins FROM users WHERE id='2'").Scan(&balance, &bjWins, &insuranceWins, &ffWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix != "!" || balance != "123456789012345678901234567890" || bjWins != 7 || insuranceWins != 3 || ffWins != 0 {
+		t.Errorf("data changed by migrations: prefix %q, balance %s, bj_wins %d, bj_insurance_wins %d, ff_wins %d",
+			prefix, balance, bjWins, insuranceWins, ffWins)
+	}
+
+	// Migrating again changes nothing
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	checkMigrated(t, db)
+}
+
+// A database that was migrated partway, and then patched by hand, only gets the migrations it has not had.
+func TestMigratePartiallyMigratedDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	execTest(t, db, "DELETE FROM schema_version WHERE version > 5;")
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 5 {
+		t.Fatalf("%d migrations left applied, want 5", len(applied))
+	}
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	checkMigrated(t, db)
+}
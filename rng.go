@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Games draw their random numbers from an rng created for each game by gameRNGs.
+// Every rng records the rolls it made so that the game can be replayed, either to verify it or to resume it.
+
+// rng is the source of randomness of a single game.
+type rng interface {
+	// Intn returns a random number in [0, n).
+	Intn(n int) int
+}
+
+// rngSource creates the rng of every new game.
+// It can be replaced to replay games or to drive exact outcomes through them in simulations and tests.
+type rngSource interface {
+	gameRNG(userID string, kind string) (rng, error)
+}
+
+// rngSourceFunc is an rngSource backed by a function.
+type rngSourceFunc func(userID string, kind string) (rng, error)
+
+func (f rngSourceFunc) gameRNG(userID string, kind string) (rng, error) {
+	return f(userID, kind)
+}
+
+var gameRNGs rngSource = fairSource{}
+
+// Sources of randomness that can be configured.
+const (
+	rngFair  = "fair"
+	rngPlain = "plain"
+)
+
+func validRNG(source string) bool {
+	return source == rngFair || source == rngPlain
+}
+
+// newGameRNG returns the rng of a new game of the given kind played by the user.
+// Every game must be finished with finishGame once its result is known.
+func newGameRNG(userID string, kind string) (rng, error) {
+	return gameRNGs.gameRNG(userID, kind)
+}
+
+// roll is a number returned by an rng along with its upper bound.
+type roll struct {
+	n int
+	v int
+}
+
+// formatRolls returns the rolls as space separated "n:v" pairs.
+func formatRolls(rolls []roll) string {
+	parts := make([]string, len(rolls))
+	for i, roll := range rolls {
+		parts[i] = strconv.Itoa(roll.n) + ":" + strconv.Itoa(roll.v)
+	}
+	return strings.Join(parts, " ")
+}
+
+func parseRolls(s string) ([]roll, error) {
+	fields := strings.Fields(s)
+	rolls := make([]roll, len(fields))
+	for i, field := range fields {
+		_, err := fmt.Sscanf(field, "%d:%d", &rolls[i].n, &rolls[i].v)
+		if err != nil || rolls[i].n < 1 {
+			return nil, fmt.Errorf("invalid roll %q", field)
+		}
+	}
+	return rolls, nil
+}
+
+// replayRolls makes the rng repeat the given rolls, failing as soon as it rolls something else.
+func replayRolls(r rng, rolls []roll) error {
+	for i, roll := range rolls {
+		if v := r.Intn(roll.n); v != roll.v {
+			return fmt.Errorf("roll %d is %d, expected %d", i+1, v, roll.v)
+		}
+	}
+	return nil
+}
+
+// seededRNG is a math/rand generator whose seed is kept, so that its game can be replayed.
+type seededRNG struct {
+	seed  int64
+	r     *rand.Rand
+	rolls []roll
+}
+
+func newSeededRNG(seed int64) *seededRNG {
+	return &seededRNG{seed: seed, r: rand.New(rand.NewSource(seed))}
+}
+
+func (r *seededRNG) Intn(n int) int {
+	v := r.r.Intn(n)
+	r.rolls = append(r.rolls, roll{n, v})
+	return v
+}
+
+// seededSource derives the seed of every game from a single seed, so that a whole run can be reproduced.
+type seededSource struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newSeededSource(seed int64) *seededSource {
+	return &seededSource{r: rand.New(rand.NewSource(seed))}
+}
+
+func (s *seededSource) gameRNG(userID string, kind string) (rng, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return newSeededRNG(s.r.Int63()), nil
+}
+
+// sequenceRNG returns predetermined numbers in order.
+// It panics once they run out or if one is out of range, since the game would not play out as intended.
+type sequenceRNG struct {
+	values []int
+}
+
+func newSequenceRNG(values ...int) *sequenceRNG {
+	return &sequenceRNG{values: values}
+}
+
+func (r *sequenceRNG) Intn(n int) int {
+	if len(r.values) == 0 {
+		panic("sequenceRNG: out of values")
+	}
+	v := r.values[0]
+	if v < 0 || v >= n {
+		panic(fmt.Sprintf("sequenceRNG: %d is not in [0, %d)", v, n))
+	}
+	r.values = r.values[1:]
+	return v
+}
+
+// cardSequence returns an rng that draws the given cards in order, as long as they are left in the deck.
+// Blackjack deals the dealer's two cards first, then the player's, then draws in the order the game is played.
+func cardSequence(cards ...string) *sequenceRNG {
+	values := make([]int, len(cards))
+	for i, card := range cards {
+		values[i] = -1
+		for j, cardType := range cardTypes {
+			if card == cardType {
+				values[i] = j
+			}
+		}
+		if values[i] == -1 {
+			panic("cardSequence: unknown card " + card)
+		}
+	}
+	return newSequenceRNG(values...)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// sessionKey identifies an interactive session.
+// ChannelID is left empty by games that only allow one session per user.
+type sessionKey struct {
+	UserID    string
+	ChannelID string
+}
+
+// session is a single interactive game, its data must only be touched while the session is locked.
+type session struct {
+	mu         sync.Mutex
+	key        sessionKey
+	data       interface{}
+	lastActive time.Time
+	ended      bool
+}
+
+// sessionManager tracks the sessions of an interactive game and expires them once they have been
+// inactive for longer than the timeout. It is safe for concurrent use.
+//
+// Locks are always taken session first, then manager, never the other way around.
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[sessionKey]*session
+	timeout  time.Duration
+	// onExpire is called with the session locked once it expires.
+	onExpire func(key sessionKey, data interface{})
+	run      sync.Once
+}
+
+var ErrSessionExists = errors.New("session already exists")
+
+func newSessionManager(timeout time.Duration, onExpire func(key sessionKey, data interface{})) *sessionManager {
+	return &sessionManager{
+		sessions: make(map[sessionKey]*session),
+		timeout:  timeout,
+		onExpire: onExpire,
+	}
+}
+
+// Start creates a session for the key, expiring any existing session that has timed out.
+// ErrSessionExists is returned if a session that is still active exists.
+func (sm *sessionManager) Start(key sessionKey, data interface{}) error {
+	for {
+		sm.mu.Lock()
+		existing, exists := sm.sessions[key]
+		if !exists {
+			sm.sessions[key] = &session{key: key, data: data, lastActive: time.Now()}
+			sm.mu.Unlock()
+			return nil
+		}
+		sm.mu.Unlock()
+
+		if !sm.expire(existing, time.Now()) {
+			return ErrSessionExists
+		}
+	}
+}
+
+// With calls fn with the data of the key's session while it is locked and marks the session as active.
+// If fn returns true the session ends. With reports whether the session existed.
+func (sm *sessionManager) With(key sessionKey, fn func(data interface{}) (end bool)) bool {
+	sm.mu.Lock()
+	s, exists := sm.sessions[key]
+	sm.mu.Unlock()
+	if !exists {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The session may have ended while waiting for the lock
+	if s.ended {
+		return false
+	}
+	s.lastActive = time.Now()
+	if fn(s.data) {
+		sm.end(s)
+	}
+	return true
+}
+
+// End ends the key's session without calling onExpire.
+func (sm *sessionManager) End(key sessionKey) {
+	sm.With(key, func(data interface{}) bool {
+		return true
+	})
+}
+
+// Range calls fn with the data of every session while it is locked.
+// Sessions started or ended during the iteration may or may not be visited.
+func (sm *sessionManager) Range(fn func(key sessionKey, data interface{})) {
+	for _, s := range sm.snapshot() {
+		s.mu.Lock()
+		if !s.ended {
+			fn(s.key, s.data)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Has reports whether the key has a session, without marking it as active.
+func (sm *sessionManager) Has(key sessionKey) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	_, exists := sm.sessions[key]
+	return exists
+}
+
+// Count returns the number of sessions that have not ended yet.
+func (sm *sessionManager) Count() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return len(sm.sessions)
+}
+
+// Run starts expiring sessions every interval in the background, only the first call has any effect.
+func (sm *sessionManager) Run(interval time.Duration) {
+	sm.run.Do(func() {
+		go func() {
+			for {
+				time.Sleep(interval)
+				now := time.Now()
+				for _, s := range sm.snapshot() {
+					sm.safeExpire(s, now)
+				}
+			}
+		}()
+	})
+}
+
+func (sm *sessionManager) snapshot() []*session {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sessions := make([]*session, 0, len(sm.sessions))
+	for _, s := range sm.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// safeExpire is expire for the background loop, a panicking onExpire must not take down the bot.
+func (sm *sessionManager) safeExpire(s *session, now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			rootLogger.Error("Expiring session panicked", "user_id", s.key.UserID, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		}
+	}()
+	sm.expire(s, now)
+}
+
+// expire ends the session and calls onExpire if it has timed out.
+// It reports whether the session is gone afterwards.
+func (sm *sessionManager) expire(s *session, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return true
+	}
+	if now.Sub(s.lastActive) <= sm.timeout {
+		return false
+	}
+	sm.end(s)
+	if sm.onExpire != nil {
+		sm.onExpire(s.key, s.data)
+	}
+	return true
+}
+
+// end removes the session, which must be locked.
+func (sm *sessionManager) end(s *session) {
+	s.ended = true
+	sm.mu.Lock()
+	if sm.sessions[s.key] == s {
+		delete(sm.sessions, s.key)
+	}
+	sm.mu.Unlock()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionExpires(t *testing.T) {
+	var expired []interface{}
+	sm := newSessionManager(50*time.Millisecond, func(key sessionKey, data interface{}) {
+		expired = append(expired, data)
+	})
+	key := sessionKey{UserID: "1"}
+	if err := sm.Start(key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Start(key, 2); err != ErrSessionExists {
+		t.Fatalf("Start of an active session returned %v, want ErrSessionExists", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !sm.Has(key) {
+		t.Fatal("session is gone before anything expired it")
+	}
+	// Starting over a timed out session expires it first
+	if err := sm.Start(key, 3); err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0] != 1 {
+		t.Fatalf("expired %v, want [1]", expired)
+	}
+	sm.With(key, func(data interface{}) bool {
+		if data != 3 {
+			t.Errorf("session data is %v, want 3", data)
+		}
+		return false
+	})
+}
+
+func TestSessionWithKeepsActive(t *testing.T) {
+	sm := newSessionManager(50*time.Millisecond, nil)
+	key := sessionKey{UserID: "1"}
+	sm.Start(key, nil)
+	for i := 0; i < 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if !sm.With(key, func(data interface{}) bool { return false }) {
+			t.Fatal("session ended while in use")
+		}
+	}
+	if sm.expire(sm.snapshot()[0], time.Now()) {
+		t.Fatal("session that was just used expired")
+	}
+
+	// Has does not count as activity
+	time.Sleep(60 * time.Millisecond)
+	sm.Has(key)
+	if !sm.expire(sm.snapshot()[0], time.Now()) {
+		t.Fatal("session that timed out did not expire")
+	}
+	if sm.With(key, func(data interface{}) bool { t.Error("With called fn of an expired session"); return false }) {
+		t.Fatal("With found an expired session")
+	}
+}
+
+// An expiry that happens while With holds the session waits for it, and does nothing if With ended the session.
+func TestSessionExpiryWaitsForWith(t *testing.T) {
+	expired := 0
+	sm := newSessionManager(time.Millisecond, func(key sessionKey, data interface{}) {
+		expired++
+	})
+	key := sessionKey{UserID: "1"}
+	sm.Start(key, nil)
+	time.Sleep(5 * time.Millisecond)
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan bool)
+	go sm.With(key, func(data interface{}) bool {
+		close(locked)
+		<-release
+		return true
+	})
+	<-locked
+	s := sm.snapshot()[0]
+	go func() {
+		done <- sm.expire(s, time.Now().Add(time.Hour))
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("session expired while With held it")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if !<-done {
+		t.Fatal("session is still there after With ended it")
+	}
+	if expired != 0 {
+		t.Fatalf("onExpire called %d times for a session With ended", expired)
+	}
+	if sm.Count() != 0 {
+		t.Fatalf("%d sessions left, want 0", sm.Count())
+	}
+}
+
+func TestSessionWithSerializes(t *testing.T) {
+	sm := newSessionManager(time.Minute, nil)
+	key := sessionKey{UserID: "1"}
+	n := new(int)
+	sm.Start(key, n)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sm.With(key, func(data interface{}) bool {
+				count := data.(*int)
+				v := *count
+				time.Sleep(time.Microsecond)
+				*count = v + 1
+				return false
+			})
+		}()
+	}
+	wg.Wait()
+	if *n != 100 {
+		t.Fatalf("count is %d after 100 increments", *n)
+	}
+}
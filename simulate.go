@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// simResponder is the Responder of simulated games, messages go nowhere.
+type simResponder struct{}
+
+func (simResponder) Send(channelID string, resp Response) (MessageRef, error) {
+	return MessageRef{ChannelID: channelID}, nil
+}
+
+func (simResponder) Edit(ref MessageRef, resp Response) error {
+	return nil
+}
+
+func (simResponder) DM(userID string, resp Response) error {
+	return nil
+}
+
+func (simResponder) User(id string) (User, error) {
+	return User{ID: id, Name: id, Mention: "<@" + id + ">"}, nil
+}
+
+// simContext is a command invoked by the simulation.
+type simContext struct {
+	simResponder
+	userID string
+	// sent counts the replies so that every message gets its own ID.
+	sent *int
+}
+
+func (sc simContext) Author() User {
+	user, _ := sc.User(sc.userID)
+	return user
+}
+
+func (sc simContext) GuildID() string {
+	return "sim"
+}
+
+func (sc simContext) ChannelID() string {
+	return "sim"
+}
+
+func (sc simContext) CanManageGuild() bool {
+	return false
+}
+
+func (sc simContext) Reply(content string) (MessageRef, error) {
+	return sc.ReplyEmbed(nil)
+}
+
+func (sc simContext) ReplyEmbed(embed *Embed, buttons ...Button) (MessageRef, error) {
+	*sc.sent++
+	return MessageRef{ChannelID: "sim", MessageID: strconv.Itoa(*sc.sent)}, nil
+}
+
+// simulateBlackjack plays games through the blackjack commands against an in-memory store, hitting below 17 and
+// standing otherwise, never taking insurance, and prints how much of the money wagered was paid back.
+// Games use gameRNGs, which should be seeded so that the simulation can be reproduced.
+func simulateBlackjack(games int) {
+	store = newMemoryStore(config.DefaultPrefix)
+	responder = simResponder{}
+	ctx := simContext{userID: "0", sent: new(int)}
+	key := sessionKey{UserID: ctx.userID}
+	bet := big.NewInt(100)
+	store.CreateUser(ctx.userID, new(big.Int).Mul(bet, big.NewInt(int64(games)*10)))
+
+	var wins, pushes, losses int
+	wagered := new(big.Int)
+	net := new(big.Int)
+	for i := 0; i < games; i++ {
+		before, err := getBalance(ctx.userID)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		args, err := commandsByAlias["blackjack"].Args.parse([]string{bet.String()})
+		if err == nil {
+			err = blackjack(ctx, args)
+		}
+		for err == nil {
+			var total int
+			var offering bool
+			var msg MessageRef
+			inProgress := blackjackSessions.With(key, func(data interface{}) bool {
+				game := data.(*blackjackGame)
+				offering = game.offering
+				// No hand is left to play if settling the game failed, any move settles it again
+				if hand := game.playing(); hand != nil {
+					total = getHandTotal(&hand.Cards)
+				}
+				msg = game.msg
+				return false
+			})
+			if !inProgress {
+				break
+			}
+			button := "bj_stand"
+			if offering {
+				button = "bj_decline"
+			} else if total < 17 {
+				button = "bj_hit"
+			}
+			err = blackjackCont(ctx, button, msg)
+		}
+		if err != nil {
+			fmt.Println("Game", i+1, "failed:", err)
+			return
+		}
+
+		after, err := getBalance(ctx.userID)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		diff := new(big.Int).Sub(after, before)
+		switch diff.Sign() {
+		case 1:
+			wins++
+		case 0:
+			pushes++
+		default:
+			losses++
+		}
+		wagered.Add(wagered, bet)
+		net.Add(net, diff)
+	}
+
+	returned := new(big.Float).Quo(new(big.Float).SetInt(new(big.Int).Add(wagered, net)), new(big.Float).SetInt(wagered))
+	fmt.Printf("%d games: %d wins, %d pushes, %d losses\n", games, wins, pushes, losses)
+	fmt.Printf("Wagered $%s, net $%s, return to player %s%%\n", wagered, net, new(big.Float).Mul(returned, big.NewFloat(100)).Text('f', 2))
+}
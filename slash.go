@@ -0,0 +1,96 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var slashNamePattern = regexp.MustCompile(`^[\w-]{1,32}$`)
+
+// slashCommandNames maps the name of each registered slash command to the command it invokes, see registerCommands.
+var slashCommandNames = make(map[string]string)
+
+// slashName returns the name the command is registered under, which is the first of its names that Discord accepts.
+func slashName(cmd *command) string {
+	for _, alias := range append([]string{cmd.Name}, cmd.Aliases...) {
+		if slashNamePattern.MatchString(alias) {
+			return alias
+		}
+	}
+	return cmd.Name
+}
+
+// slashOptionName returns the name of the option the param is passed as, Discord does not allow spaces in them.
+func slashOptionName(p param) string {
+	return strings.ReplaceAll(p.Name, " ", "_")
+}
+
+// slashOptions returns the options of the command when invoked as a slash command, one for each param.
+func slashOptions(cmd *command) []*discordgo.ApplicationCommandOption {
+	options := make([]*discordgo.ApplicationCommandOption, 0, len(cmd.Args))
+	for _, p := range cmd.Args {
+		option := &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        slashOptionName(p),
+			Description: p.Description,
+			Required:    !p.Optional,
+		}
+		switch p.Kind {
+		case argUser:
+			option.Type = discordgo.ApplicationCommandOptionUser
+		case argPage:
+			option.Type = discordgo.ApplicationCommandOptionInteger
+		case argChoice:
+			for _, choice := range p.Choices {
+				option.Choices = append(option.Choices, &discordgo.ApplicationCommandOptionChoice{Name: choice, Value: choice})
+			}
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+func registerSlashCommands(s *discordgo.Session) {
+	cmds := make([]*discordgo.ApplicationCommand, 0, len(commands))
+	for _, cmd := range commands {
+		cmds = append(cmds, &discordgo.ApplicationCommand{
+			Name:        slashName(cmd),
+			Description: cmd.Description,
+			Options:     slashOptions(cmd),
+		})
+	}
+	_, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", cmds)
+	if err != nil {
+		rootLogger.Error("Could not register slash commands", "error", err)
+	}
+}
+
+// slashArgs converts the options of a slash command into the arguments its handler expects, in the order of its
+// signature.
+func slashArgs(data discordgo.ApplicationCommandInteractionData) []string {
+	args := make([]string, 0, len(data.Options))
+	cmd, exists := lookupCommand(slashCommandNames[data.Name])
+	if !exists {
+		return args
+	}
+	for _, p := range cmd.Args {
+		for _, option := range data.Options {
+			if option.Name != slashOptionName(p) {
+				continue
+			}
+			switch option.Type {
+			case discordgo.ApplicationCommandOptionInteger:
+				args = append(args, strconv.FormatInt(option.IntValue(), 10))
+			case discordgo.ApplicationCommandOptionString:
+				args = append(args, option.StringValue())
+			default:
+				// Users are passed by ID
+				args = append(args, option.Value.(string))
+			}
+		}
+	}
+	return args
+}
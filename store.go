@@ -0,0 +1,586 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store is the persistence layer behind every economy operation.
+// Command handlers must go through it instead of touching a database directly.
+type Store interface {
+	Prefix(guildID string) (string, error)
+	SetPrefix(guildID string, prefix string) error
+	// ServerTier returns the tier stored in servers.type, servers that were never seen are serverDefault.
+	ServerTier(guildID string) (string, error)
+	SetServerTier(guildID string, tier string) error
+	// BlackjackRules returns the name of the blackjack rules the guild picked, it is empty if it did not pick any.
+	BlackjackRules(guildID string) (string, error)
+	SetBlackjackRules(guildID string, rules string) error
+
+	// CreateUser creates the user with the given starting balance if they do not exist yet.
+	CreateUser(id string, balance *big.Int) error
+	DeleteUser(id string) error
+	// UserTier returns the tier stored in users.type.
+	UserTier(id string) (string, error)
+	SetUserTier(id string, tier string) error
+	UserCount() (int64, error)
+	TopUsers(offset int, limit int) ([]UserBalance, error)
+
+	Balance(id string) (*big.Int, error)
+	// Transact applies every change in a single transaction and returns the resulting balances in the same order.
+	// Nothing is applied if any debit would leave its account below zero, in which case ErrInsufficientFunds is returned.
+	// Every change is recorded in the ledger.
+	Transact(changes ...BalanceChange) ([]*big.Int, error)
+	// History returns the user's ledger entries, newest first.
+	History(id string, offset int, limit int) ([]LedgerEntry, error)
+	HistoryCount(id string) (int64, error)
+
+	Stat(id string, stat string) (int, error)
+	AddStat(id string, stat string, d int) error
+
+	Daily(id string) (int64, error)
+	SetDaily(id string, t int64) error
+	// ClaimDaily credits the reward and sets the user's daily to next in a single transaction, unless their daily is
+	// at or after now, in which case ErrDailyClaimed is returned and nothing changes. It returns the new balance.
+	ClaimDaily(id string, now int64, next int64, reward *big.Int) (*big.Int, error)
+
+	// SaveGame creates or replaces the user's in-flight game of the same kind.
+	SaveGame(game SavedGame) error
+	DeleteGame(userID string, kind string) error
+	// Games returns every in-flight game of the given kind.
+	Games(kind string) ([]SavedGame, error)
+
+	// Seeds returns the user's provably fair seeds, storing the given seeds first if the user has none.
+	Seeds(userID string, initial FairSeeds) (FairSeeds, error)
+	// RotateSeeds replaces the user's seeds. It returns ErrSeedInUse and changes nothing if a game played with the
+	// current server seed is not finished yet, since the seed is revealed once it is replaced.
+	RotateSeeds(userID string, seeds FairSeeds) error
+	// StartFairGame records a new game played with the user's current seeds and increments their nonce.
+	// The user must already have seeds.
+	StartFairGame(userID string, kind string) (FairGame, error)
+	// FinishFairGame records the rolls and result of a game once it is over.
+	FinishFairGame(id int64, rolls string, result string) error
+	FairGame(id int64) (FairGame, error)
+
+	// UseCommand records that the user used the command with the given cooldown at time now, unless they already did
+	// less than seconds before. In that case nothing is recorded and the time the command can be used again is returned
+	// along with ErrOnCooldown.
+	UseCommand(userID string, cooldown string, now int64, seconds int64) (int64, error)
+
+	RecordAdminAction(action AdminAction) error
+	// ApplyAdminChange applies the change and records its action in a single transaction, recording any balance
+	// change in the ledger with the admin as the counterparty. It returns the balance before and after the change, or
+	// ErrInsufficientFunds if the balance would go below zero, in which case nothing changes.
+	ApplyAdminChange(change AdminChange) (*big.Int, *big.Int, error)
+
+	Close() error
+}
+
+// UserBalance is a single row of the leaderboard.
+type UserBalance struct {
+	ID      string
+	Balance *big.Int
+}
+
+// BalanceChange adds Amount to the balance of the user with the given ID, a negative Amount is a debit.
+// Reason and Counterparty are recorded in the ledger.
+type BalanceChange struct {
+	ID           string
+	Amount       *big.Int
+	Reason       string
+	Counterparty string
+}
+
+// SavedGame is an in-flight game persisted so that it survives restarts.
+type SavedGame struct {
+	UserID     string
+	Kind       string
+	State      []byte
+	Bet        *big.Int
+	Msg        MessageRef
+	LastActive int64
+}
+
+var ErrUnknownUser = errors.New("unknown user")
+var ErrInsufficientFunds = errors.New("insufficient funds")
+var ErrUnknownStat = errors.New("unknown stat")
+var ErrUnknownTier = errors.New("unknown tier")
+var ErrDailyClaimed = errors.New("daily already claimed")
+
+var statNames = []string{"games", "ff_wins", "ff_losses", "bj_wins", "bj_losses", "bj_insurance_wins", "bj_insurance_losses"}
+
+func validStat(stat string) bool {
+	for _, s := range statNames {
+		if stat == s {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryUser struct {
+	balance *big.Int
+	daily   int64
+	stats   map[string]int
+	tier    string
+}
+
+// memoryStore keeps everything in memory, it is lost when the bot exits.
+type memoryStore struct {
+	mu       sync.Mutex
+	prefixes map[string]string
+	// serverTiers only holds servers whose tier was changed.
+	serverTiers map[string]string
+	// blackjackRules holds the rules each guild picked.
+	blackjackRules map[string]string
+	users          map[string]*memoryUser
+	ledger         map[string][]LedgerEntry
+	games          map[string]map[string]SavedGame
+	seeds          map[string]FairSeeds
+	// fairGames is indexed by game ID - 1.
+	fairGames    []FairGame
+	adminActions []AdminAction
+	// cooldowns holds the last time each user used each cooldown.
+	cooldowns map[string]map[string]int64
+	// defaultPrefix is the prefix of servers that have not set one.
+	defaultPrefix string
+}
+
+func newMemoryStore(defaultPrefix string) *memoryStore {
+	return &memoryStore{
+		defaultPrefix:  defaultPrefix,
+		prefixes:       make(map[string]string),
+		serverTiers:    make(map[string]string),
+		blackjackRules: make(map[string]string),
+		users:          make(map[string]*memoryUser),
+		ledger:         make(map[string][]LedgerEntry),
+		games:          make(map[string]map[string]SavedGame),
+		seeds:          make(map[string]FairSeeds),
+		cooldowns:      make(map[string]map[string]int64),
+	}
+}
+
+func (ms *memoryStore) Prefix(guildID string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	prefix, exists := ms.prefixes[guildID]
+	if !exists {
+		prefix = ms.defaultPrefix
+		ms.prefixes[guildID] = prefix
+	}
+	return prefix, nil
+}
+
+func (ms *memoryStore) SetPrefix(guildID string, prefix string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.prefixes[guildID] = prefix
+	return nil
+}
+
+func (ms *memoryStore) ServerTier(guildID string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	tier, exists := ms.serverTiers[guildID]
+	if !exists {
+		return serverDefault, nil
+	}
+	return tier, nil
+}
+
+func (ms *memoryStore) SetServerTier(guildID string, tier string) error {
+	if !validServerTier(tier) {
+		return ErrUnknownTier
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.serverTiers[guildID] = tier
+	return nil
+}
+
+func (ms *memoryStore) BlackjackRules(guildID string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.blackjackRules[guildID], nil
+}
+
+func (ms *memoryStore) SetBlackjackRules(guildID string, rules string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.blackjackRules[guildID] = rules
+	return nil
+}
+
+func (ms *memoryStore) CreateUser(id string, balance *big.Int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, exists := ms.users[id]; !exists {
+		ms.users[id] = &memoryUser{
+			balance: new(big.Int).Set(balance),
+			stats:   make(map[string]int),
+			tier:    userDefault,
+		}
+	}
+	return nil
+}
+
+func (ms *memoryStore) DeleteUser(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.users, id)
+	return nil
+}
+
+func (ms *memoryStore) UserTier(id string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, err := ms.user(id)
+	if err != nil {
+		return "", err
+	}
+	return user.tier, nil
+}
+
+func (ms *memoryStore) SetUserTier(id string, tier string) error {
+	if !validUserTier(tier) {
+		return ErrUnknownTier
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, err := ms.user(id)
+	if err != nil {
+		return err
+	}
+	user.tier = tier
+	return nil
+}
+
+func (ms *memoryStore) UserCount() (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return int64(len(ms.users)), nil
+}
+
+func (ms *memoryStore) TopUsers(offset int, limit int) ([]UserBalance, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	all := make([]UserBalance, 0, len(ms.users))
+	for id, user := range ms.users {
+		all = append(all, UserBalance{ID: id, Balance: new(big.Int).Set(user.balance)})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Balance.Cmp(all[j].Balance) == 1
+	})
+	if offset >= len(all) {
+		return []UserBalance{}, nil
+	}
+	all = all[offset:]
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+func (ms *memoryStore) user(id string) (*memoryUser, error) {
+	user, exists := ms.users[id]
+	if !exists {
+		return nil, ErrUnknownUser
+	}
+	return user, nil
+}
+
+func (ms *memoryStore) Balance(id string) (*big.Int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, err := ms.user(id)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Set(user.balance), nil
+}
+
+func (ms *memoryStore) Transact(changes ...BalanceChange) ([]*big.Int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.transact(changes)
+}
+
+// transact applies the changes, ms.mu must be held.
+func (ms *memoryStore) transact(changes []BalanceChange) ([]*big.Int, error) {
+	// Work on copies so that nothing is applied if any change fails
+	pending := make(map[string]*big.Int)
+	results := make([]*big.Int, len(changes))
+	now := time.Now().Unix()
+	for i, change := range changes {
+		bal, exists := pending[change.ID]
+		if !exists {
+			user, err := ms.user(change.ID)
+			if err != nil {
+				return nil, err
+			}
+			bal = new(big.Int).Set(user.balance)
+			pending[change.ID] = bal
+		}
+		bal.Add(bal, change.Amount)
+		if change.Amount.Sign() < 0 && bal.Sign() < 0 {
+			return nil, ErrInsufficientFunds
+		}
+		results[i] = new(big.Int).Set(bal)
+	}
+
+	for id, bal := range pending {
+		ms.users[id].balance = bal
+	}
+	for i, change := range changes {
+		ms.ledger[change.ID] = append(ms.ledger[change.ID], LedgerEntry{
+			UserID:       change.ID,
+			Amount:       new(big.Int).Set(change.Amount),
+			Balance:      new(big.Int).Set(results[i]),
+			Reason:       change.Reason,
+			Counterparty: change.Counterparty,
+			Time:         now,
+		})
+	}
+	return results, nil
+}
+
+func (ms *memoryStore) History(id string, offset int, limit int) ([]LedgerEntry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	entries := ms.ledger[id]
+	history := make([]LedgerEntry, 0, limit)
+	for i := len(entries) - 1 - offset; i >= 0 && len(history) < limit; i-- {
+		history = append(history, entries[i])
+	}
+	return history, nil
+}
+
+func (ms *memoryStore) HistoryCount(id string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return int64(len(ms.ledger[id])), nil
+}
+
+func (ms *memoryStore) Stat(id string, stat string) (int, error) {
+	if !validStat(stat) {
+		return 0, ErrUnknownStat
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, err := ms.user(id)
+	if err != nil {
+		return 0, err
+	}
+	return user.stats[stat], nil
+}
+
+func (ms *memoryStore) AddStat(id string, stat string, d int) error {
+	if !validStat(stat) {
+		return ErrUnknownStat
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, err := ms.user(id)
+	if err != nil {
+		return err
+	}
+	user.stats[stat] += d
+	return nil
+}
+
+func (ms *memoryStore) Daily(id string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, err := ms.user(id)
+	if err != nil {
+		return 0, err
+	}
+	return user.daily, nil
+}
+
+func (ms *memoryStore) SetDaily(id string, t int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, err := ms.user(id)
+	if err != nil {
+		return err
+	}
+	user.daily = t
+	return nil
+}
+
+func (ms *memoryStore) ClaimDaily(id string, now int64, next int64, reward *big.Int) (*big.Int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, err := ms.user(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.daily >= now {
+		return nil, ErrDailyClaimed
+	}
+	balances, err := ms.transact([]BalanceChange{{ID: id, Amount: reward, Reason: reasonDaily}})
+	if err != nil {
+		return nil, err
+	}
+	user.daily = next
+	return balances[0], nil
+}
+
+func (ms *memoryStore) SaveGame(game SavedGame) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.games[game.Kind] == nil {
+		ms.games[game.Kind] = make(map[string]SavedGame)
+	}
+	ms.games[game.Kind][game.UserID] = game
+	return nil
+}
+
+func (ms *memoryStore) DeleteGame(userID string, kind string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.games[kind], userID)
+	return nil
+}
+
+func (ms *memoryStore) Games(kind string) ([]SavedGame, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	games := make([]SavedGame, 0, len(ms.games[kind]))
+	for _, game := range ms.games[kind] {
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+func (ms *memoryStore) Seeds(userID string, initial FairSeeds) (FairSeeds, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	seeds, exists := ms.seeds[userID]
+	if !exists {
+		seeds = initial
+		ms.seeds[userID] = seeds
+	}
+	return seeds, nil
+}
+
+func (ms *memoryStore) RotateSeeds(userID string, seeds FairSeeds) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	current, exists := ms.seeds[userID]
+	for _, game := range ms.fairGames {
+		if exists && game.UserID == userID && game.ServerSeed == current.ServerSeed && game.Result == "" {
+			return ErrSeedInUse
+		}
+	}
+	ms.seeds[userID] = seeds
+	return nil
+}
+
+func (ms *memoryStore) StartFairGame(userID string, kind string) (FairGame, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	seeds, exists := ms.seeds[userID]
+	if !exists {
+		return FairGame{}, ErrUnknownUser
+	}
+	game := FairGame{
+		ID:         int64(len(ms.fairGames)) + 1,
+		UserID:     userID,
+		Kind:       kind,
+		ServerSeed: seeds.ServerSeed,
+		ClientSeed: seeds.ClientSeed,
+		Nonce:      seeds.Nonce,
+		Time:       time.Now().Unix(),
+	}
+	ms.fairGames = append(ms.fairGames, game)
+	seeds.Nonce++
+	ms.seeds[userID] = seeds
+	return game, nil
+}
+
+func (ms *memoryStore) FinishFairGame(id int64, rolls string, result string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if id < 1 || id > int64(len(ms.fairGames)) {
+		return ErrUnknownGame
+	}
+	ms.fairGames[id-1].Rolls = rolls
+	ms.fairGames[id-1].Result = result
+	return nil
+}
+
+func (ms *memoryStore) FairGame(id int64) (FairGame, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if id < 1 || id > int64(len(ms.fairGames)) {
+		return FairGame{}, ErrUnknownGame
+	}
+	return ms.fairGames[id-1], nil
+}
+
+func (ms *memoryStore) UseCommand(userID string, cooldown string, now int64, seconds int64) (int64, error) {
+	if !validCooldown(cooldown) {
+		return 0, fmt.Errorf("unknown cooldown %q", cooldown)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.cooldowns[userID] == nil {
+		ms.cooldowns[userID] = make(map[string]int64)
+	}
+	if available := ms.cooldowns[userID][cooldown] + seconds; available > now {
+		return available, ErrOnCooldown
+	}
+	ms.cooldowns[userID][cooldown] = now
+	return 0, nil
+}
+
+func (ms *memoryStore) RecordAdminAction(action AdminAction) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.adminActions = append(ms.adminActions, action)
+	return nil
+}
+
+func (ms *memoryStore) ApplyAdminChange(change AdminChange) (*big.Int, *big.Int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	action := change.Action
+	user, err := ms.user(action.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	old := user.balance
+	balance := change.balance(old)
+	if balance.Sign() < 0 {
+		return nil, nil, ErrInsufficientFunds
+	}
+	if change.changesBalance() {
+		user.balance = new(big.Int).Set(balance)
+		ms.ledger[action.UserID] = append(ms.ledger[action.UserID], LedgerEntry{
+			UserID:       action.UserID,
+			Amount:       new(big.Int).Sub(balance, old),
+			Balance:      new(big.Int).Set(balance),
+			Reason:       change.LedgerReason,
+			Counterparty: action.AdminID,
+			Time:         action.Time,
+		})
+	}
+	if change.ResetStats {
+		user.stats = make(map[string]int)
+	}
+	if change.ResetDaily {
+		user.daily = 0
+	}
+	ms.adminActions = append(ms.adminActions, change.record(old, balance))
+	return old, balance, nil
+}
+
+func (ms *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStore is the Store backed by sqlite.db.
+type sqliteStore struct {
+	db *sql.DB
+	// defaultPrefix is the prefix of servers that have not set one.
+	defaultPrefix string
+}
+
+func openSQLite(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err == nil {
+		rootLogger.Info("Using existing database", "path", path)
+	} else {
+		rootLogger.Info("No existing database found, creating it", "path", path)
+		file, err := os.Create(path)
+		file.Close()
+		if err != nil {
+			return nil, errors.New("Could not create " + path + ": " + err.Error())
+		}
+		rootLogger.Info("Created new database", "path", path)
+	}
+
+	// Transactions take the write lock immediately so that concurrent balance changes wait for each other
+	// instead of failing when they try to upgrade a read lock.
+	db, err := sql.Open("sqlite3", path+"?_txlock=immediate&_busy_timeout=5000")
+	if err != nil {
+		return nil, errors.New("Could not open " + path + ": " + err.Error())
+	}
+	return db, nil
+}
+
+func newSQLiteStore(path string, defaultPrefix string) (*sqliteStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{db: db, defaultPrefix: defaultPrefix}, nil
+}
+
+func (ss *sqliteStore) Prefix(guildID string) (string, error) {
+	defer sqliteQueryDuration.since(time.Now(), "Prefix")
+	row, err := ss.db.Query("SELECT prefix FROM servers WHERE id=?", guildID)
+	if err != nil {
+		return "", err
+	}
+	defer row.Close()
+	if row.Next() {
+		var prefix string
+		err = row.Scan(&prefix)
+		return prefix, err
+	}
+	row.Close()
+	_, err = ss.db.Exec("INSERT INTO servers (id, prefix) VALUES (?, ?)", guildID, ss.defaultPrefix)
+	if err != nil {
+		return "", err
+	}
+	return ss.defaultPrefix, nil
+}
+
+func (ss *sqliteStore) SetPrefix(guildID string, prefix string) error {
+	defer sqliteQueryDuration.since(time.Now(), "SetPrefix")
+	_, err := ss.db.Exec("UPDATE servers SET prefix=? WHERE id=?", prefix, guildID)
+	return err
+}
+
+func (ss *sqliteStore) ServerTier(guildID string) (string, error) {
+	defer sqliteQueryDuration.since(time.Now(), "ServerTier")
+	var tier string
+	err := ss.db.QueryRow("SELECT type FROM servers WHERE id=?", guildID).Scan(&tier)
+	if err == sql.ErrNoRows {
+		return serverDefault, nil
+	}
+	return tier, err
+}
+
+func (ss *sqliteStore) SetServerTier(guildID string, tier string) error {
+	defer sqliteQueryDuration.since(time.Now(), "SetServerTier")
+	if !validServerTier(tier) {
+		return ErrUnknownTier
+	}
+	_, err := ss.db.Exec("INSERT INTO servers (id, type, prefix) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET type=excluded.type", guildID, tier, ss.defaultPrefix)
+	return err
+}
+
+func (ss *sqliteStore) BlackjackRules(guildID string) (string, error) {
+	defer sqliteQueryDuration.since(time.Now(), "BlackjackRules")
+	var rules string
+	err := ss.db.QueryRow("SELECT blackjack_rules FROM servers WHERE id=?", guildID).Scan(&rules)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return rules, err
+}
+
+func (ss *sqliteStore) SetBlackjackRules(guildID string, rules string) error {
+	defer sqliteQueryDuration.since(time.Now(), "SetBlackjackRules")
+	_, err := ss.db.Exec("INSERT INTO servers (id, blackjack_rules, prefix) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET blackjack_rules=excluded.blackjack_rules", guildID, rules, ss.defaultPrefix)
+	return err
+}
+
+func (ss *sqliteStore) CreateUser(id string, balance *big.Int) error {
+	defer sqliteQueryDuration.since(time.Now(), "CreateUser")
+	_, err := ss.db.Exec("INSERT OR IGNORE INTO users (id, type, balance, games, daily, ff_wins, ff_losses, bj_wins, bj_losses) VALUES (?, 'DEFAULT', ?, 0, 0, 0, 0, 0, 0)", id, balance.String())
+	return err
+}
+
+func (ss *sqliteStore) DeleteUser(id string) error {
+	defer sqliteQueryDuration.since(time.Now(), "DeleteUser")
+	_, err := ss.db.Exec("DELETE FROM users WHERE id=?", id)
+	return err
+}
+
+func (ss *sqliteStore) UserTier(id string) (string, error) {
+	defer sqliteQueryDuration.since(time.Now(), "UserTier")
+	var tier string
+	err := ss.db.QueryRow("SELECT type FROM users WHERE id=?", id).Scan(&tier)
+	if err == sql.ErrNoRows {
+		return "", ErrUnknownUser
+	}
+	return tier, err
+}
+
+func (ss *sqliteStore) SetUserTier(id string, tier string) error {
+	defer sqliteQueryDuration.since(time.Now(), "SetUserTier")
+	if !validUserTier(tier) {
+		return ErrUnknownTier
+	}
+	res, err := ss.db.Exec("UPDATE users SET type=? WHERE id=?", tier, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return ErrUnknownUser
+	}
+	return err
+}
+
+func (ss *sqliteStore) UserCount() (int64, error) {
+	defer sqliteQueryDuration.since(time.Now(), "UserCount")
+	var count int64
+	err := ss.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+func (ss *sqliteStore) TopUsers(offset int, limit int) ([]UserBalance, error) {
+	defer sqliteQueryDuration.since(time.Now(), "TopUsers")
+	rows, err := ss.db.Query("SELECT id, balance FROM users ORDER BY CAST(balance AS DECIMAL(100, 100)) DESC LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	top := make([]UserBalance, 0, limit)
+	for rows.Next() {
+		var id string
+		var balanceStr string
+		err = rows.Scan(&id, &balanceStr)
+		if err != nil {
+			return nil, err
+		}
+		balance, err := parseBalance(balanceStr)
+		if err != nil {
+			return nil, err
+		}
+		top = append(top, UserBalance{ID: id, Balance: balance})
+	}
+	return top, rows.Err()
+}
+
+func parseBalance(balanceStr string) (*big.Int, error) {
+	balance, suc := new(big.Int).SetString(balanceStr, 10)
+	if !suc {
+		return nil, errors.New("Could not interpret balance: " + balanceStr)
+	}
+	return balance, nil
+}
+
+func (ss *sqliteStore) Balance(id string) (*big.Int, error) {
+	defer sqliteQueryDuration.since(time.Now(), "Balance")
+	var balanceStr string
+	err := ss.db.QueryRow("SELECT balance FROM users WHERE id=?", id).Scan(&balanceStr)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseBalance(balanceStr)
+}
+
+func (ss *sqliteStore) Transact(changes ...BalanceChange) ([]*big.Int, error) {
+	defer sqliteQueryDuration.since(time.Now(), "Transact")
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results, err := transact(tx, changes)
+	if err != nil {
+		return nil, err
+	}
+	return results, tx.Commit()
+}
+
+// transact applies the changes within tx, which is left for the caller to commit.
+func transact(tx *sql.Tx, changes []BalanceChange) ([]*big.Int, error) {
+	results := make([]*big.Int, len(changes))
+	now := time.Now().Unix()
+	for i, change := range changes {
+		var balanceStr string
+		err := tx.QueryRow("SELECT balance FROM users WHERE id=?", change.ID).Scan(&balanceStr)
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownUser
+		}
+		if err != nil {
+			return nil, err
+		}
+		bal, err := parseBalance(balanceStr)
+		if err != nil {
+			return nil, err
+		}
+		bal.Add(bal, change.Amount)
+		if change.Amount.Sign() < 0 && bal.Sign() < 0 {
+			return nil, ErrInsufficientFunds
+		}
+		_, err = tx.Exec("UPDATE users SET balance=? WHERE id=?", bal.String(), change.ID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec("INSERT INTO ledger (user_id, amount, balance, reason, counterparty, time) VALUES (?, ?, ?, ?, ?, ?)",
+			change.ID, change.Amount.String(), bal.String(), change.Reason, change.Counterparty, now)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = bal
+	}
+	return results, nil
+}
+
+func (ss *sqliteStore) History(id string, offset int, limit int) ([]LedgerEntry, error) {
+	defer sqliteQueryDuration.since(time.Now(), "History")
+	rows, err := ss.db.Query("SELECT amount, balance, reason, counterparty, time FROM ledger WHERE user_id=? ORDER BY id DESC LIMIT ? OFFSET ?", id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]LedgerEntry, 0, limit)
+	for rows.Next() {
+		entry := LedgerEntry{UserID: id}
+		var amountStr string
+		var balanceStr string
+		err = rows.Scan(&amountStr, &balanceStr, &entry.Reason, &entry.Counterparty, &entry.Time)
+		if err != nil {
+			return nil, err
+		}
+		entry.Amount, err = parseBalance(amountStr)
+		if err != nil {
+			return nil, err
+		}
+		entry.Balance, err = parseBalance(balanceStr)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+func (ss *sqliteStore) HistoryCount(id string) (int64, error) {
+	defer sqliteQueryDuration.since(time.Now(), "HistoryCount")
+	var count int64
+	err := ss.db.QueryRow("SELECT COUNT(*) FROM ledger WHERE user_id=?", id).Scan(&count)
+	return count, err
+}
+
+func (ss *sqliteStore) Stat(id string, stat string) (int, error) {
+	defer sqliteQueryDuration.since(time.Now(), "Stat")
+	if !validStat(stat) {
+		return 0, ErrUnknownStat
+	}
+	var c int
+	err := ss.db.QueryRow("SELECT `"+stat+"` FROM users WHERE id=?", id).Scan(&c)
+	if err == sql.ErrNoRows {
+		return 0, ErrUnknownUser
+	}
+	return c, err
+}
+
+func (ss *sqliteStore) AddStat(id string, stat string, d int) error {
+	defer sqliteQueryDuration.since(time.Now(), "AddStat")
+	if !validStat(stat) {
+		return ErrUnknownStat
+	}
+	_, err := ss.db.Exec("UPDATE users SET `"+stat+"`=`"+stat+"`+? WHERE id=?", d, id)
+	return err
+}
+
+func (ss *sqliteStore) Daily(id string) (int64, error) {
+	defer sqliteQueryDuration.since(time.Now(), "Daily")
+	var daily int64
+	err := ss.db.QueryRow("SELECT daily FROM users WHERE id=?", id).Scan(&daily)
+	if err == sql.ErrNoRows {
+		return 0, ErrUnknownUser
+	}
+	return daily, err
+}
+
+func (ss *sqliteStore) SetDaily(id string, t int64) error {
+	defer sqliteQueryDuration.since(time.Now(), "SetDaily")
+	_, err := ss.db.Exec("UPDATE users SET daily=? WHERE id=?", t, id)
+	return err
+}
+
+func (ss *sqliteStore) ClaimDaily(id string, now int64, next int64, reward *big.Int) (*big.Int, error) {
+	defer sqliteQueryDuration.since(time.Now(), "ClaimDaily")
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Only one of two concurrent claims can move daily forward, the other one then sees it as claimed
+	res, err := tx.Exec("UPDATE users SET daily=? WHERE id=? AND daily < ?", next, id, now)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		var daily int64
+		err = tx.QueryRow("SELECT daily FROM users WHERE id=?", id).Scan(&daily)
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownUser
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrDailyClaimed
+	}
+	balances, err := transact(tx, []BalanceChange{{ID: id, Amount: reward, Reason: reasonDaily}})
+	if err != nil {
+		return nil, err
+	}
+	return balances[0], tx.Commit()
+}
+
+func (ss *sqliteStore) SaveGame(game SavedGame) error {
+	defer sqliteQueryDuration.since(time.Now(), "SaveGame")
+	_, err := ss.db.Exec("INSERT OR REPLACE INTO games (user_id, kind, state, bet, channel_id, message_id, last_active) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		game.UserID, game.Kind, game.State, game.Bet.String(), game.Msg.ChannelID, game.Msg.MessageID, game.LastActive)
+	return err
+}
+
+func (ss *sqliteStore) DeleteGame(userID string, kind string) error {
+	defer sqliteQueryDuration.since(time.Now(), "DeleteGame")
+	_, err := ss.db.Exec("DELETE FROM games WHERE user_id=? AND kind=?", userID, kind)
+	return err
+}
+
+func (ss *sqliteStore) Games(kind string) ([]SavedGame, error) {
+	defer sqliteQueryDuration.since(time.Now(), "Games")
+	rows, err := ss.db.Query("SELECT user_id, state, bet, channel_id, message_id, last_active FROM games WHERE kind=?", kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := make([]SavedGame, 0)
+	for rows.Next() {
+		game := SavedGame{Kind: kind}
+		var betStr string
+		err = rows.Scan(&game.UserID, &game.State, &betStr, &game.Msg.ChannelID, &game.Msg.MessageID, &game.LastActive)
+		if err != nil {
+			return nil, err
+		}
+		game.Bet, err = parseBalance(betStr)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+	return games, rows.Err()
+}
+
+func (ss *sqliteStore) Seeds(userID string, initial FairSeeds) (FairSeeds, error) {
+	defer sqliteQueryDuration.since(time.Now(), "Seeds")
+	_, err := ss.db.Exec("INSERT OR IGNORE INTO seeds (user_id, server_seed, client_seed, nonce) VALUES (?, ?, ?, ?)",
+		userID, initial.ServerSeed, initial.ClientSeed, initial.Nonce)
+	if err != nil {
+		return FairSeeds{}, err
+	}
+	var seeds FairSeeds
+	err = ss.db.QueryRow("SELECT server_seed, client_seed, nonce FROM seeds WHERE user_id=?", userID).Scan(&seeds.ServerSeed, &seeds.ClientSeed, &seeds.Nonce)
+	return seeds, err
+}
+
+func (ss *sqliteStore) RotateSeeds(userID string, seeds FairSeeds) error {
+	defer sqliteQueryDuration.since(time.Now(), "RotateSeeds")
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Checked in the same transaction, so that no game can start with the seed between the check and the rotation
+	var unfinished int64
+	err = tx.QueryRow("SELECT COUNT(*) FROM fair_games JOIN seeds ON seeds.user_id=fair_games.user_id AND seeds.server_seed=fair_games.server_seed WHERE fair_games.user_id=? AND fair_games.result=''", userID).
+		Scan(&unfinished)
+	if err != nil {
+		return err
+	}
+	if unfinished > 0 {
+		return ErrSeedInUse
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO seeds (user_id, server_seed, client_seed, nonce) VALUES (?, ?, ?, ?)",
+		userID, seeds.ServerSeed, seeds.ClientSeed, seeds.Nonce)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ss *sqliteStore) StartFairGame(userID string, kind string) (FairGame, error) {
+	defer sqliteQueryDuration.since(time.Now(), "StartFairGame")
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return FairGame{}, err
+	}
+	defer tx.Rollback()
+
+	game := FairGame{UserID: userID, Kind: kind, Time: time.Now().Unix()}
+	err = tx.QueryRow("SELECT server_seed, client_seed, nonce FROM seeds WHERE user_id=?", userID).Scan(&game.ServerSeed, &game.ClientSeed, &game.Nonce)
+	if err == sql.ErrNoRows {
+		return FairGame{}, ErrUnknownUser
+	}
+	if err != nil {
+		return FairGame{}, err
+	}
+	_, err = tx.Exec("UPDATE seeds SET nonce=nonce+1 WHERE user_id=?", userID)
+	if err != nil {
+		return FairGame{}, err
+	}
+	res, err := tx.Exec("INSERT INTO fair_games (user_id, kind, server_seed, client_seed, nonce, time) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, kind, game.ServerSeed, game.ClientSeed, game.Nonce, game.Time)
+	if err != nil {
+		return FairGame{}, err
+	}
+	game.ID, err = res.LastInsertId()
+	if err != nil {
+		return FairGame{}, err
+	}
+	return game, tx.Commit()
+}
+
+func (ss *sqliteStore) FinishFairGame(id int64, rolls string, result string) error {
+	defer sqliteQueryDuration.since(time.Now(), "FinishFairGame")
+	res, err := ss.db.Exec("UPDATE fair_games SET rolls=?, result=? WHERE id=?", rolls, result, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return ErrUnknownGame
+	}
+	return err
+}
+
+func (ss *sqliteStore) FairGame(id int64) (FairGame, error) {
+	defer sqliteQueryDuration.since(time.Now(), "FairGame")
+	game := FairGame{ID: id}
+	err := ss.db.QueryRow("SELECT user_id, kind, server_seed, client_seed, nonce, rolls, result, time FROM fair_games WHERE id=?", id).
+		Scan(&game.UserID, &game.Kind, &game.ServerSeed, &game.ClientSeed, &game.Nonce, &game.Rolls, &game.Result, &game.Time)
+	if err == sql.ErrNoRows {
+		return FairGame{}, ErrUnknownGame
+	}
+	return game, err
+}
+
+func (ss *sqliteStore) UseCommand(userID string, cooldown string, now int64, seconds int64) (int64, error) {
+	defer sqliteQueryDuration.since(time.Now(), "UseCommand")
+	if !validCooldown(cooldown) {
+		return 0, fmt.Errorf("unknown cooldown %q", cooldown)
+	}
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT OR IGNORE INTO cooldowns (user_id) VALUES (?)", userID)
+	if err != nil {
+		return 0, err
+	}
+	var last int64
+	err = tx.QueryRow("SELECT `"+cooldown+"` FROM cooldowns WHERE user_id=?", userID).Scan(&last)
+	if err != nil {
+		return 0, err
+	}
+	if available := last + seconds; available > now {
+		return available, ErrOnCooldown
+	}
+	_, err = tx.Exec("UPDATE cooldowns SET `"+cooldown+"`=? WHERE user_id=?", now, userID)
+	if err != nil {
+		return 0, err
+	}
+	return 0, tx.Commit()
+}
+
+func (ss *sqliteStore) RecordAdminAction(action AdminAction) error {
+	defer sqliteQueryDuration.since(time.Now(), "RecordAdminAction")
+	_, err := ss.db.Exec("INSERT INTO admin_actions (admin_id, user_id, action, detail, reason, time) VALUES (?, ?, ?, ?, ?, ?)",
+		action.AdminID, action.UserID, action.Action, action.Detail, action.Reason, action.Time)
+	return err
+}
+
+func (ss *sqliteStore) ApplyAdminChange(change AdminChange) (*big.Int, *big.Int, error) {
+	defer sqliteQueryDuration.since(time.Now(), "ApplyAdminChange")
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	action := change.Action
+	var balanceStr string
+	err = tx.QueryRow("SELECT balance FROM users WHERE id=?", action.UserID).Scan(&balanceStr)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	old, err := parseBalance(balanceStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	balance := change.balance(old)
+	if balance.Sign() < 0 {
+		return nil, nil, ErrInsufficientFunds
+	}
+
+	set := []string{"balance=?"}
+	if change.ResetStats {
+		for _, stat := range statNames {
+			set = append(set, "`"+stat+"`=0")
+		}
+	}
+	if change.ResetDaily {
+		set = append(set, "daily=0")
+	}
+	_, err = tx.Exec("UPDATE users SET "+strings.Join(set, ", ")+" WHERE id=?", balance.String(), action.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if change.changesBalance() {
+		_, err = tx.Exec("INSERT INTO ledger (user_id, amount, balance, reason, counterparty, time) VALUES (?, ?, ?, ?, ?, ?)",
+			action.UserID, new(big.Int).Sub(balance, old).String(), balance.String(), change.LedgerReason, action.AdminID, action.Time)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	recorded := change.record(old, balance)
+	_, err = tx.Exec("INSERT INTO admin_actions (admin_id, user_id, action, detail, reason, time) VALUES (?, ?, ?, ?, ?, ?)",
+		recorded.AdminID, recorded.UserID, recorded.Action, recorded.Detail, recorded.Reason, recorded.Time)
+	if err != nil {
+		return nil, nil, err
+	}
+	return old, balance, tx.Commit()
+}
+
+func (ss *sqliteStore) Close() error {
+	return ss.db.Close()
+}
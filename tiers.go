@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// User tiers are stored in users.type.
+const (
+	userDefault      = "DEFAULT"
+	userOwner        = "OWNER"
+	userEconomyAdmin = "ECONOMY_ADMIN"
+	userBanned       = "BANNED"
+)
+
+// Server tiers are stored in servers.type.
+const (
+	serverDefault = "DEFAULT"
+	serverPremium = "PREMIUM"
+)
+
+// userTierNames maps the names users type to the tiers they stand for, the names are the choices of the tier commands.
+var userTierNames = map[string]string{
+	"default": userDefault,
+	"owner":   userOwner,
+	"admin":   userEconomyAdmin,
+	"banned":  userBanned,
+}
+
+var serverTierNames = map[string]string{
+	"default": serverDefault,
+	"premium": serverPremium,
+}
+
+func validUserTier(tier string) bool {
+	return tier == userDefault || tier == userOwner || tier == userEconomyAdmin || tier == userBanned
+}
+
+func validServerTier(tier string) bool {
+	return tier == serverDefault || tier == serverPremium
+}
+
+// tierName returns the name users type for the tier.
+func tierName(names map[string]string, tier string) string {
+	for name, t := range names {
+		if t == tier {
+			return name
+		}
+	}
+	return strings.ToLower(tier)
+}
+
+// userTier returns the tier of the user. The owners in the config are always owners, whatever is stored.
+func userTier(id string) (string, error) {
+	for _, owner := range config.Owners {
+		if id == owner {
+			return userOwner, nil
+		}
+	}
+	tier, err := store.UserTier(id)
+	if err == ErrUnknownUser {
+		return userDefault, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not get tier of %s: %w", id, err)
+	}
+	return tier, nil
+}
+
+// hasTier reports whether the user has any of the tiers.
+func hasTier(id string, tiers ...string) (bool, error) {
+	tier, err := userTier(id)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range tiers {
+		if tier == t {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isAdmin reports whether the user may manage the economy.
+func isAdmin(id string) (bool, error) {
+	return hasTier(id, userOwner, userEconomyAdmin)
+}
+
+func isBanned(id string) (bool, error) {
+	return hasTier(id, userBanned)
+}
+
+func serverTier(guildID string) (string, error) {
+	tier, err := store.ServerTier(guildID)
+	if err != nil {
+		return "", fmt.Errorf("could not get tier of server %s: %w", guildID, err)
+	}
+	return tier, nil
+}
+
+// requireServerTier refuses the command in servers that do not have the tier.
+func requireServerTier(tier string) middleware {
+	return func(name string, next handler) handler {
+		return func(ctx Context, args Args) error {
+			current, err := serverTier(ctx.GuildID())
+			if err != nil {
+				return err
+			}
+			if current != tier {
+				ctx.Reply("`" + name + "` can only be used in " + tierName(serverTierNames, tier) + " servers.")
+				return nil
+			}
+			return next(ctx, args)
+		}
+	}
+}
+
+// economy refuses the command to users who are banned from the economy.
+func economy(name string, next handler) handler {
+	return func(ctx Context, args Args) error {
+		banned, err := isBanned(ctx.Author().ID)
+		if err != nil {
+			return err
+		}
+		if banned {
+			ctx.Reply(ctx.Author().Mention + " you are banned from the economy and cannot use `" + name + "`.")
+			return nil
+		}
+		return next(ctx, args)
+	}
+}
+
+func tier(ctx Context, args Args) error {
+	id := ctx.Author().ID
+	if args.Has("user") {
+		id = args.UserID("user")
+	}
+	user, err := createUser(ctx, id)
+	if err != nil {
+		ctx.Reply(ctx.Author().Mention + " " + id + " is not a valid User ID.\nPlease ping the user or copy their ID and paste it.")
+		return nil
+	}
+	current, err := userTier(id)
+	if err != nil {
+		return err
+	}
+	if !args.Has("tier") {
+		ctx.Reply(user.Name + " is " + tierName(userTierNames, current) + ".")
+		return nil
+	}
+	if !args.Has("user") {
+		ctx.Reply("Invalid syntax: you need to specify the user whose tier to change.")
+		return nil
+	}
+
+	owner, err := hasTier(ctx.Author().ID, userOwner)
+	if err != nil {
+		return err
+	}
+	if !owner {
+		ctx.Reply(ctx.Author().Mention + " only bot owners can change tiers.")
+		return nil
+	}
+	next := userTierNames[args.String("tier")]
+	err = store.SetUserTier(id, next)
+	if err != nil {
+		return fmt.Errorf("could not set tier of %s to %s: %w", id, next, err)
+	}
+	ctx.Reply(user.Name + " is now " + tierName(userTierNames, next) + ".")
+	return nil
+}
+
+func servertier(ctx Context, args Args) error {
+	current, err := serverTier(ctx.GuildID())
+	if err != nil {
+		return err
+	}
+	if !args.Has("tier") {
+		ctx.Reply("This server is " + tierName(serverTierNames, current) + ".")
+		return nil
+	}
+
+	owner, err := hasTier(ctx.Author().ID, userOwner)
+	if err != nil {
+		return err
+	}
+	if !owner {
+		ctx.Reply(ctx.Author().Mention + " only bot owners can change tiers.")
+		return nil
+	}
+	next := serverTierNames[args.String("tier")]
+	err = store.SetServerTier(ctx.GuildID(), next)
+	if err != nil {
+		return fmt.Errorf("could not set tier of server %s to %s: %w", ctx.GuildID(), next, err)
+	}
+	ctx.Reply("This server is now " + tierName(serverTierNames, next) + ".")
+	return nil
+}